@@ -2,9 +2,14 @@
 package adaptd
 
 import (
+	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
+	"sort"
 	"strings"
+	"time"
 )
 
 // Adapter is a type that helps with http middleware.
@@ -16,6 +21,39 @@ type Adapter func(http.Handler) http.Handler
 // logged in, then the Adapter might redirect to another page.
 type HandlerChecker func(http.ResponseWriter, *http.Request) bool
 
+// And returns a HandlerChecker that is true only if all the checks are true.
+// The checks are called in order and stop at the first one that is false.
+func And(checks ...HandlerChecker) HandlerChecker {
+	return func(w http.ResponseWriter, r *http.Request) bool {
+		for _, check := range checks {
+			if !check(w, r) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// Or returns a HandlerChecker that is true if any of the checks are true.
+// The checks are called in order and stop at the first one that is true.
+func Or(checks ...HandlerChecker) HandlerChecker {
+	return func(w http.ResponseWriter, r *http.Request) bool {
+		for _, check := range checks {
+			if check(w, r) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Not returns a HandlerChecker that is true when the check is false.
+func Not(check HandlerChecker) HandlerChecker {
+	return func(w http.ResponseWriter, r *http.Request) bool {
+		return !check(w, r)
+	}
+}
+
 // Adapt is a helper to add all the adapters required for a given http.Handler.
 // Adapters will be called in the order they are given when the returned http.Handler is called.
 func Adapt(h http.Handler, adapters ...Adapter) http.Handler {
@@ -27,12 +65,50 @@ func Adapt(h http.Handler, adapters ...Adapter) http.Handler {
 	return h
 }
 
+// AdaptFunc is like Adapt, but for a handler function.
+func AdaptFunc(f func(http.ResponseWriter, *http.Request), adapters ...Adapter) http.Handler {
+	return Adapt(http.HandlerFunc(f), adapters...)
+}
+
+// Chain is a reusable list of Adapters.
+// Chains are never changed once created, so a base Chain can be safely extended in different ways.
+type Chain struct {
+	adapters []Adapter
+}
+
+// NewChain creates a Chain of the adapters, called in the order they are given.
+func NewChain(adapters ...Adapter) Chain {
+	return Chain{adapters: append([]Adapter(nil), adapters...)}
+}
+
+// Append returns a new Chain with the adapters added to the end of the Chain.
+func (c Chain) Append(adapters ...Adapter) Chain {
+	a := make([]Adapter, 0, len(c.adapters)+len(adapters))
+	a = append(a, c.adapters...)
+	return Chain{adapters: append(a, adapters...)}
+}
+
+// Extend returns a new Chain with the adapters of other added to the end of the Chain.
+func (c Chain) Extend(other Chain) Chain {
+	return c.Append(other.adapters...)
+}
+
+// Then applies the adapters of the Chain to the handler, like Adapt.
+func (c Chain) Then(h http.Handler) http.Handler {
+	return Adapt(h, c.adapters...)
+}
+
 // Notify adapter logs when the request is beginning to be processed and when it is finished.
+// The finishing log includes the status code of the response and the time taken.
 func Notify(logger *log.Logger) Adapter {
 	return func(h http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			logger.Printf("Handling %v request at URL %v\n", r.Method, r.URL)
-			defer logger.Printf("%v request at URL %v was handled\n", r.Method, r.URL)
+			w, sr := captureStatus(w, r)
+			start := time.Now()
+			defer func() {
+				logger.Printf("%v request at URL %v was handled with status %v in %v\n", r.Method, r.URL, sr.status, time.Since(start))
+			}()
 			h.ServeHTTP(w, r)
 		})
 	}
@@ -43,6 +119,17 @@ func Notify(logger *log.Logger) Adapter {
 // The other handler is provided to create the Adapter while the get handler should be provided to the Adapter
 // e.g. GetAndOtherRequest(other, http.MethodPost)(getHandler)
 func GetAndOtherRequest(other http.Handler, method string) Adapter {
+	return GetAndOtherRequestWithHandler(other, method, nil)
+}
+
+// GetAndOtherRequestWithHandler adapter is like GetAndOtherRequest except that requests with other methods
+// are passed to the notAllowed handler after the Allow header is set. This allows, for example, JSON errors for APIs.
+// If notAllowed is nil, a http.StatusMethodNotAllowed error is given.
+func GetAndOtherRequestWithHandler(other http.Handler, method string, notAllowed http.Handler) Adapter {
+	if notAllowed == nil {
+		notAllowed = methodNotAllowedHandler
+	}
+	allowHeader := joinMethods(map[string]bool{http.MethodGet: true, method: true})
 	return func(h http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			switch r.Method {
@@ -54,27 +141,93 @@ func GetAndOtherRequest(other http.Handler, method string) Adapter {
 				h.ServeHTTP(w, r)
 			default:
 				// We are not allowing this method so respond with an error
-				http.Error(w, "Request method not allowed", http.StatusMethodNotAllowed)
+				w.Header().Set("Allow", allowHeader)
+				notAllowed.ServeHTTP(w, r)
 			}
 		})
 	}
 }
 
+// MethodRouter adapter uses a different handler for each request method in the map.
+// The handler provided to the Adapter is the fallback for the methods not in the map,
+// e.g. MethodRouter(map[string]http.Handler{http.MethodPost: postHandler, http.MethodDelete: deleteHandler})(defaultHandler)
+// OPTIONS requests are answered with the Allow header listing the methods in the map, unless an OPTIONS handler is in the map.
+// If the provided handler is nil, requests with other methods are given a http.StatusMethodNotAllowed error.
+func MethodRouter(handlers map[string]http.Handler) Adapter {
+	return func(h http.Handler) http.Handler {
+		routes := make(map[string]http.Handler, len(handlers))
+		for method, handler := range handlers {
+			routes[method] = handler
+		}
+		allowed := make(map[string]bool, len(routes)+1)
+		for method := range routes {
+			allowed[method] = true
+		}
+		allowed[http.MethodOptions] = true
+		allowHeader := joinMethods(allowed)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if handler, ok := routes[r.Method]; ok {
+				handler.ServeHTTP(w, r)
+				return
+			}
+			if r.Method != http.MethodOptions && h != nil {
+				h.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Set("Allow", allowHeader)
+			if r.Method == http.MethodOptions {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			// We are not allowing this method so respond with an error
+			methodNotAllowedHandler.ServeHTTP(w, r)
+		})
+	}
+}
+
 // RequestMethod adapter allow allows the given request method.
 // All other requests are given a http.StatusMethodNotAllowed error.
 func RequestMethod(method string) Adapter {
+	return AllowMethods(method)
+}
+
+// AllowMethods adapter only allows the given request methods.
+// All other requests are given a http.StatusMethodNotAllowed error
+// with the Allow header listing the allowed methods.
+func AllowMethods(methods ...string) Adapter {
+	return AllowMethodsWithHandler(nil, methods...)
+}
+
+// AllowMethodsWithHandler adapter is like AllowMethods except that requests with other methods
+// are passed to the notAllowed handler after the Allow header is set. This allows, for example, JSON errors for APIs.
+// If notAllowed is nil, a http.StatusMethodNotAllowed error is given.
+func AllowMethodsWithHandler(notAllowed http.Handler, methods ...string) Adapter {
+	if notAllowed == nil {
+		notAllowed = methodNotAllowedHandler
+	}
+	allowed := make(map[string]bool, len(methods))
+	for _, m := range methods {
+		allowed[m] = true
+	}
+	allowHeader := joinMethods(allowed)
 	return func(h http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if r.Method == method {
+			if allowed[r.Method] {
 				h.ServeHTTP(w, r)
-			} else {
-				// We are not allowing this method so respond with an error
-				http.Error(w, "Request method not allowed", http.StatusMethodNotAllowed)
+				return
 			}
+			// We are not allowing this method so respond with an error
+			w.Header().Set("Allow", allowHeader)
+			notAllowed.ServeHTTP(w, r)
 		})
 	}
 }
 
+// methodNotAllowedHandler is the default response for requests with methods that are not allowed.
+var methodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	http.Error(w, "Request method not allowed", http.StatusMethodNotAllowed)
+})
+
 // AddHeader adapter adds the header before calling the handler
 func AddHeader(name, value string) Adapter {
 	return func(h http.Handler) http.Handler {
@@ -85,6 +238,24 @@ func AddHeader(name, value string) Adapter {
 	}
 }
 
+// AddHeaders adapter adds all the headers in the map before calling the handler.
+// Headers are set, so adapters (or the handler) called later can overwrite these values.
+func AddHeaders(headers map[string]string) Adapter {
+	// Copy the headers so later changes to the map do not affect the adapter.
+	hs := make(map[string]string, len(headers))
+	for name, value := range headers {
+		hs[name] = value
+	}
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for name, value := range hs {
+				w.Header().Set(name, value)
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
 // AddHeaderWithFunc adds the header before calling the handler.
 // This is useful for things like CSRF tokens.
 func AddHeaderWithFunc(name string, tg func() string) Adapter {
@@ -98,23 +269,77 @@ func AddHeaderWithFunc(name string, tg func() string) Adapter {
 
 // AddCookieWithFunc adds the header before calling the handler.
 // This is useful for things like CSRF tokens.
+// If the function returns an error, a http.StatusInternalServerError error is given instead of calling the handler.
 func AddCookieWithFunc(name string, tg func(http.ResponseWriter, *http.Request) error) Adapter {
+	return AddCookieWithErrorHandler(name, tg, nil)
+}
+
+// AddCookieWithErrorHandler adds the cookie before calling the handler, like AddCookieWithFunc.
+// If the function returns an error, the errorHandler is called instead of the handler.
+// If errorHandler is nil, a http.StatusInternalServerError error is given.
+func AddCookieWithErrorHandler(name string, tg func(http.ResponseWriter, *http.Request) error, errorHandler http.Handler) Adapter {
+	if errorHandler == nil {
+		errorHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		})
+	}
 	return func(h http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			tg(w, r)
+			if err := tg(w, r); err != nil {
+				packageLogger().Printf("Error adding cookie %v: %v\n", name, err)
+				errorHandler.ServeHTTP(w, r)
+				return
+			}
 			h.ServeHTTP(w, r)
 		})
 	}
 }
 
+// SetCookie adapter adds the cookie before calling the handler.
+// The cookie is copied, so later changes to it do not affect the adapter.
+func SetCookie(cookie *http.Cookie) Adapter {
+	c := *cookie
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.SetCookie(w, &c)
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ClearCookie adapter tells the client to delete the cookie with the name and the path "/" before calling the handler.
+// To delete a cookie with a different path or domain, use SetCookie with the same path and domain and a MaxAge of -1.
+func ClearCookie(name string) Adapter {
+	return SetCookie(&http.Cookie{Name: name, Path: "/", MaxAge: -1, Expires: time.Unix(0, 0)})
+}
+
 // DisallowLongerPaths adapter calls the notFoundHandler if the URL path is longer than the registered one.
 // For example, paths that do not match any registered handler are sent to the handler for "/".
 // Adding this Adapter could display at custom 404 page.
+// Requests that a http.ServeMux matched to the path as a pattern with wildcards, e.g. /users/{id}, are passed to the handler.
 func DisallowLongerPaths(path string, notFoundHandler http.Handler) Adapter {
 	return func(h http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if r.URL.Path != path {
-				log.Printf("Handler expects URL %v but received a request at %v\n", path, r.URL.Path)
+			if r.URL.Path != path && !matchedPattern(r, path) {
+				packageLogger().Printf("Handler expects URL %v but received a request at %v\n", path, r.URL.Path)
+				notFoundHandler.ServeHTTP(w, r)
+				return
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// DisallowLongerPathsPrefix adapter is like DisallowLongerPaths, but allows the prefix and the paths one segment below it.
+// For example, with the prefix /files/, requests for /files/ and /files/report.pdf are passed to the handler,
+// but /files/2020/report.pdf is sent to the notFoundHandler.
+func DisallowLongerPathsPrefix(prefix string, notFoundHandler http.Handler) Adapter {
+	base := strings.TrimSuffix(prefix, "/") + "/"
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rest, ok := strings.CutPrefix(r.URL.Path, base)
+			if r.URL.Path != prefix && (!ok || strings.Contains(rest, "/")) {
+				packageLogger().Printf("Handler expects URLs directly below %v but received a request at %v\n", base, r.URL.Path)
 				notFoundHandler.ServeHTTP(w, r)
 				return
 			}
@@ -123,35 +348,106 @@ func DisallowLongerPaths(path string, notFoundHandler http.Handler) Adapter {
 	}
 }
 
+// matchedPattern reports whether a http.ServeMux matched the request to the path as an exact pattern,
+// rather than as a subtree pattern ending in a slash, which matches every longer path.
+func matchedPattern(r *http.Request, path string) bool {
+	route := routePattern(r)
+	return route == path && !strings.HasSuffix(route, "/")
+}
+
 // HTTPSRedirect adapter redirects all HTTP requests to HTTPS requests.
 // Most users should simply call this as go http.ListenAndServe(":80", HTTPSRedirect("443"))
 func HTTPSRedirect(port string) http.Handler {
+	return HTTPSRedirectWithStatus(port, http.StatusTemporaryRedirect)
+}
+
+// HTTPSRedirectPermanent adapter redirects all HTTP requests to HTTPS requests with a permanent redirect.
+// Browsers cache permanent redirects, so this is best suited for production use.
+func HTTPSRedirectPermanent(port string) http.Handler {
+	return HTTPSRedirectWithStatus(port, http.StatusPermanentRedirect)
+}
+
+// HTTPSRedirectWithStatus adapter redirects all HTTP requests to HTTPS requests using the given redirect status.
+// Use http.StatusPermanentRedirect for a permanent redirect that preserves the request method.
+// It panics if the status is not a redirect status.
+func HTTPSRedirectWithStatus(port string, status int) http.Handler {
+	mustBeRedirectStatus(status)
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		target := "https://" + strings.Split(r.Host, ":")[0] + ":" + port + r.URL.Path
+		target := "https://" + net.JoinHostPort(stripPort(r.Host), port) + r.URL.EscapedPath()
 		if len(r.URL.RawQuery) > 0 {
 			target += "?" + r.URL.RawQuery
 		}
-		log.Printf("HTTP request redirected to: %s", target)
-		http.Redirect(w, r, target, http.StatusTemporaryRedirect)
+		if len(r.URL.Fragment) > 0 {
+			target += "#" + r.URL.EscapedFragment()
+		}
+		packageLogger().Printf("HTTP request redirected to: %s", target)
+		redirectIfNotStarted(w, r, target, status)
 	})
 }
 
+// HTTPSOptions are the options for EnsureHTTPSWithOptions.
+type HTTPSOptions struct {
+	// AllowXForwardedProto allows 'X-Forwarded-Proto == "https"' to indicate that the request was made with https protocol.
+	// This is insecure unless every request comes through a proxy that sets the header, because clients can send it themselves.
+	// Use TrustedProxies instead.
+	AllowXForwardedProto bool
+	// TrustedProxies are the networks of the proxies whose X-Forwarded-Proto header is honored.
+	// If set, the header is only honored when the request comes directly from one of these proxies,
+	// regardless of AllowXForwardedProto.
+	TrustedProxies []net.IPNet
+	// AllowXForwardedHost uses the X-Forwarded-Host header as the host of the redirect target
+	// when the request comes directly from one of the TrustedProxies. Otherwise the Host of the request is used.
+	AllowXForwardedHost bool
+	// Status is the redirect status used. The default is http.StatusTemporaryRedirect.
+	Status int
+	// StrictTransportSecurity is the value of the Strict-Transport-Security header added to HTTPS responses.
+	// The header is not added if this is empty.
+	StrictTransportSecurity string
+}
+
 // EnsureHTTPS adapter redirects an HTTP request to an HTTPS request.
 // Some hosts forward requests and use 'X-Forward-Proto == "https"'
 // to indicate that he request was made with https protocol.
 // If you would like to allow this as a valid check, then the parameter should be true.
+// This is insecure unless every request comes through such a host, because clients can send the header themselves
+// to avoid the redirect. Use EnsureHTTPSFromProxies to only trust the header from known proxies.
 func EnsureHTTPS(allowXForwardedProto bool) Adapter {
+	return EnsureHTTPSWithOptions(HTTPSOptions{AllowXForwardedProto: allowXForwardedProto})
+}
+
+// EnsureHTTPSFromProxies adapter redirects an HTTP request to an HTTPS request.
+// The X-Forwarded-Proto header is only used to indicate that the request was made with https protocol
+// when the request comes directly from one of the trusted proxies.
+func EnsureHTTPSFromProxies(trustedProxies []net.IPNet) Adapter {
+	return EnsureHTTPSWithOptions(HTTPSOptions{TrustedProxies: trustedProxies})
+}
+
+// EnsureHTTPSWithOptions adapter redirects an HTTP request to an HTTPS request as configured by the options.
+// It panics if the status in the options is not a redirect status.
+func EnsureHTTPSWithOptions(opts HTTPSOptions) Adapter {
+	if opts.Status == 0 {
+		opts.Status = http.StatusTemporaryRedirect
+	}
+	mustBeRedirectStatus(opts.Status)
 	return func(h http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if !isHTTPS(r, allowXForwardedProto) {
-				target := "https://" + r.Host + r.URL.Path
+			if !opts.isHTTPS(r) {
+				// The default HTTP port should not be carried over, but any other port is kept.
+				host := r.Host
+				if opts.AllowXForwardedHost {
+					host = forwardedHost(r, opts.TrustedProxies)
+				}
+				target := "https://" + strings.TrimSuffix(host, ":80") + r.URL.Path
 				if len(r.URL.RawQuery) > 0 {
 					target += "?" + r.URL.RawQuery
 				}
-				log.Printf("redirect to: %s", target)
-				http.Redirect(w, r, target, http.StatusTemporaryRedirect)
+				packageLogger().Printf("redirect to: %s", target)
+				redirectIfNotStarted(w, r, target, opts.Status)
 				return
 			}
+			if opts.StrictTransportSecurity != "" {
+				w.Header().Set("Strict-Transport-Security", opts.StrictTransportSecurity)
+			}
 			h.ServeHTTP(w, r)
 		})
 	}
@@ -164,8 +460,8 @@ func OnCheck(f HandlerChecker, falseHandler http.Handler, logOnFalse string) Ada
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			defer func() {
 				if e := recover(); e != nil {
-					log.Println(e)
-					log.Println(logOnFalse)
+					packageLogger().Println(e)
+					packageLogger().Println(logOnFalse)
 					falseHandler.ServeHTTP(w, r)
 				} else {
 					h.ServeHTTP(w, r)
@@ -178,12 +474,160 @@ func OnCheck(f HandlerChecker, falseHandler http.Handler, logOnFalse string) Ada
 	}
 }
 
+// ApplyIf adapter applies the given Adapter only to requests for which the function returns true.
+// Other requests are passed directly to the handler.
+func ApplyIf(cond HandlerChecker, a Adapter) Adapter {
+	return func(h http.Handler) http.Handler {
+		adapted := a(h)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if cond(w, r) {
+				adapted.ServeHTTP(w, r)
+				return
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// FeatureFlag adapter serves the handler only for requests for which the feature with the given name is enabled.
+// The enabled function gets the full request, so it can decide by user, cookie, or a percentage rollout.
+// Other requests are served by the disabled handler. If disabled is nil, a http.StatusNotFound error
+// is given to hide the feature. It panics if enabled is nil.
+func FeatureFlag(name string, enabled func(*http.Request) bool, disabled http.Handler) Adapter {
+	if enabled == nil {
+		panic(fmt.Sprintf("adaptd: feature flag %q has no enabled function", name))
+	}
+	if disabled == nil {
+		disabled = http.NotFoundHandler()
+	}
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !enabled(r) {
+				disabled.ServeHTTP(w, r)
+				return
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Skip adapter applies the given Adapter to all requests except those whose path is one of the exempt paths.
+// A path ending in * exempts every path starting with the text before it, e.g. /static/* exempts /static/app.js.
+// Exempt requests are passed directly to the handler.
+// For example, Skip(EnsureHTTPS(false), "/healthz") lets health checks use HTTP.
+func Skip(a Adapter, paths ...string) Adapter {
+	exempt := func(w http.ResponseWriter, r *http.Request) bool {
+		for _, p := range paths {
+			if prefix, ok := strings.CutSuffix(p, "*"); ok {
+				if strings.HasPrefix(r.URL.Path, prefix) {
+					return true
+				}
+			} else if r.URL.Path == p {
+				return true
+			}
+		}
+		return false
+	}
+	return ApplyIf(Not(exempt), a)
+}
+
+// OnPrefix adapter applies the given Adapters only to requests whose path is the prefix or is below it.
+// For example, the prefix /api matches /api and /api/users, but not /apiary.
+// Other requests are passed directly to the handler.
+func OnPrefix(prefix string, adapters ...Adapter) Adapter {
+	underPrefix := func(w http.ResponseWriter, r *http.Request) bool {
+		return hasPathPrefix(r.URL.Path, prefix)
+	}
+	return ApplyIf(underPrefix, func(h http.Handler) http.Handler {
+		return Adapt(h, adapters...)
+	})
+}
+
 // CheckAndRedirect adapter checks the return of the function. On false, it redirects to the given URL.
 // On true, it will call the handler passed to the Adapater.
 func CheckAndRedirect(f HandlerChecker, redirect http.Handler, logOnRedirect string) Adapter {
 	return OnCheck(f, redirect, logOnRedirect+" redirecting")
 }
 
+func mustBeRedirectStatus(status int) {
+	switch status {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+	default:
+		panic(fmt.Sprintf("adaptd: %v is not a redirect status", status))
+	}
+}
+
+// hasPathPrefix reports whether the path is the prefix or a path below it.
+func hasPathPrefix(path, prefix string) bool {
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+	return len(path) == len(prefix) || strings.HasSuffix(prefix, "/") || path[len(prefix)] == '/'
+}
+
+// joinMethods returns the sorted, comma-separated list of methods suitable for an Allow header.
+func joinMethods(methods map[string]bool) string {
+	list := make([]string, 0, len(methods))
+	for m := range methods {
+		list = append(list, m)
+	}
+	sort.Strings(list)
+	return strings.Join(list, ", ")
+}
+
 func isHTTPS(r *http.Request, allowXForwardedProto bool) bool {
-	return (r.TLS != nil && r.TLS.HandshakeComplete) || (allowXForwardedProto && r.Header.Get("X-Forwarded-Proto") == "https")
+	return (r.TLS != nil && r.TLS.HandshakeComplete) || (allowXForwardedProto && forwardedProto(r) == "https")
+}
+
+// isHTTPS reports whether the request was made with https protocol, honoring X-Forwarded-Proto as configured.
+func (o HTTPSOptions) isHTTPS(r *http.Request) bool {
+	if len(o.TrustedProxies) == 0 {
+		return isHTTPS(r, o.AllowXForwardedProto)
+	}
+	return isHTTPS(r, false) || (ipInNets(peerIP(r), o.TrustedProxies) && forwardedProto(r) == "https")
+}
+
+// forwardedProto returns the protocol of the original request from the X-Forwarded-Proto header in lower case.
+// If the request passed through multiple proxies, the header can have comma-separated values
+// and the first is the protocol used by the client.
+func forwardedProto(r *http.Request) string {
+	proto := strings.Split(r.Header.Get("X-Forwarded-Proto"), ",")[0]
+	return strings.ToLower(strings.TrimSpace(proto))
+}
+
+// forwardedHost returns the host from the X-Forwarded-Host header if the request comes directly from
+// one of the trusted proxies, otherwise the Host of the request. Proxies append to the header,
+// so only the last value, which was added by the trusted proxy, is used; earlier values can be sent by the client.
+// The Host of the request is also used if the value is not a valid host with an optional port.
+func forwardedHost(r *http.Request, trustedProxies []net.IPNet) string {
+	if ip := peerIP(r); ip == nil || !ipInNets(ip, trustedProxies) {
+		return r.Host
+	}
+	values := r.Header.Values("X-Forwarded-Host")
+	if len(values) == 0 {
+		return r.Host
+	}
+	hosts := strings.Split(values[len(values)-1], ",")
+	if host := strings.TrimSpace(hosts[len(hosts)-1]); isValidHost(host) {
+		return host
+	}
+	return r.Host
+}
+
+// isValidHost reports whether the host is a host name or IP address with an optional port,
+// so it cannot change the meaning of a URL it is put in, e.g. with a path or user information.
+func isValidHost(host string) bool {
+	if host == "" {
+		return false
+	}
+	for i := 0; i < len(host); i++ {
+		switch c := host[i]; {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case c == '-' || c == '.' || c == ':' || c == '[' || c == ']':
+		default:
+			return false
+		}
+	}
+	u, err := url.Parse("http://" + host)
+	return err == nil && u.Host == host && u.Hostname() != ""
 }
@@ -1,10 +1,14 @@
 package adaptd
 
 import (
+	"bytes"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -37,6 +41,208 @@ func TestHTTPSRedirectHTTPS(t *testing.T) {
 	}
 }
 
+func TestEnsureHTTPSWithStatus(t *testing.T) {
+	ts := httptest.NewServer(EnsureHTTPSWithOptions(HTTPSOptions{Status: http.StatusPermanentRedirect})(http.HandlerFunc(handlerTester)))
+	defer ts.Close()
+
+	client := ts.Client()
+	client.CheckRedirect = checkRedirect
+	resp, err := client.Get(ts.URL)
+
+	if err == nil || resp.StatusCode != http.StatusPermanentRedirect {
+		t.Error("HTTP request not redirected with the given status")
+	}
+}
+
+func TestEnsureHTTPSFromProxies(t *testing.T) {
+	_, trusted, _ := net.ParseCIDR("10.0.0.0/8")
+	h := EnsureHTTPSFromProxies([]net.IPNet{*trusted})(http.HandlerFunc(handlerTester))
+	for remoteAddr, expected := range map[string]int{
+		"10.1.2.3:1234":    http.StatusOK,
+		"203.0.113.9:1234": http.StatusTemporaryRedirect,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("X-Forwarded-Proto", "https")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != expected {
+			t.Errorf("X-Forwarded-Proto from %v should give %v, got %v", remoteAddr, expected, w.Code)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	req.RemoteAddr = "10.1.2.3:1234"
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusTemporaryRedirect {
+		t.Error("HTTP request from a trusted proxy without the header should be redirected")
+	}
+}
+
+func TestEnsureHTTPSFromProxiesWithRealIP(t *testing.T) {
+	_, trusted, _ := net.ParseCIDR("10.0.0.0/8")
+	nets := []net.IPNet{*trusted}
+	h := Adapt(http.HandlerFunc(handlerTester), RealIP(nets), EnsureHTTPSFromProxies(nets))
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	req.RemoteAddr = "10.1.2.3:1234"
+	req.Header.Set("X-Forwarded-For", "203.0.113.9")
+	req.Header.Set("X-Forwarded-Proto", "https")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("X-Forwarded-Proto from a trusted proxy should be honored after RealIP, got %v", w.Code)
+	}
+}
+
+func TestEnsureHTTPSForwardedHost(t *testing.T) {
+	_, trusted, _ := net.ParseCIDR("10.0.0.0/8")
+	h := EnsureHTTPSWithOptions(HTTPSOptions{TrustedProxies: []net.IPNet{*trusted}, AllowXForwardedHost: true})(http.HandlerFunc(handlerTester))
+	for remoteAddr, location := range map[string]string{
+		"10.1.2.3:1234":    "https://www.example.com/login",
+		"203.0.113.9:1234": "https://internal:8080/login",
+	} {
+		req := httptest.NewRequest(http.MethodGet, "http://internal:8080/login", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("X-Forwarded-Host", "www.example.com")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != http.StatusTemporaryRedirect || w.Header().Get("Location") != location {
+			t.Errorf("Request from %v should redirect to %v, got %v", remoteAddr, location, w.Header().Get("Location"))
+		}
+	}
+}
+
+func TestEnsureHTTPSForwardedHostSpoofed(t *testing.T) {
+	_, trusted, _ := net.ParseCIDR("10.0.0.0/8")
+	h := EnsureHTTPSWithOptions(HTTPSOptions{TrustedProxies: []net.IPNet{*trusted}, AllowXForwardedHost: true})(http.HandlerFunc(handlerTester))
+	for _, c := range []struct {
+		forwarded []string
+		location  string
+	}{
+		{[]string{"evil.com, www.example.com"}, "https://www.example.com/login"},
+		{[]string{"evil.com", "www.example.com:8443"}, "https://www.example.com:8443/login"},
+		{[]string{"evil.com/www.example.com"}, "https://internal/login"},
+		{[]string{"www.example.com@evil.com"}, "https://internal/login"},
+		{[]string{"www.example.com evil.com"}, "https://internal/login"},
+		{[]string{"www.example.com:https"}, "https://internal/login"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "http://internal/login", nil)
+		req.RemoteAddr = "10.1.2.3:1234"
+		for _, value := range c.forwarded {
+			req.Header.Add("X-Forwarded-Host", value)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Header().Get("Location") != c.location {
+			t.Errorf("X-Forwarded-Host %q should redirect to %v, got %v", c.forwarded, c.location, w.Header().Get("Location"))
+		}
+	}
+}
+
+func TestEnsureHTTPSKeepsPort(t *testing.T) {
+	h := EnsureHTTPS(false)(http.HandlerFunc(handlerTester))
+	for host, location := range map[string]string{
+		"example.com:8443": "https://example.com:8443/login?next=1",
+		"example.com:80":   "https://example.com/login?next=1",
+		"example.com":      "https://example.com/login?next=1",
+	} {
+		req := httptest.NewRequest(http.MethodGet, "http://"+host+"/login?next=1", nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != http.StatusTemporaryRedirect || w.Header().Get("Location") != location {
+			t.Errorf("Request to %v should redirect to %v, got %v", host, location, w.Header().Get("Location"))
+		}
+	}
+}
+
+func TestEnsureHTTPSForwardedProto(t *testing.T) {
+	checkNumber = 0
+	h := EnsureHTTPSWithOptions(HTTPSOptions{AllowXForwardedProto: true, StrictTransportSecurity: "max-age=31536000"})(http.HandlerFunc(handlerTester))
+	for proto, allowed := range map[string]bool{"https": true, "HTTPS, http": true, " https ,https": true, "http, https": false, "http": false} {
+		checkNumber = 0
+		req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+		req.Header.Set("X-Forwarded-Proto", proto)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if allowed && (w.Code != http.StatusOK || checkNumber != 1 || w.Header().Get("Strict-Transport-Security") != "max-age=31536000") {
+			t.Errorf("X-Forwarded-Proto %q should be treated as HTTPS", proto)
+		}
+		if !allowed && (w.Code != http.StatusTemporaryRedirect || checkNumber != 0 || w.Header().Get("Strict-Transport-Security") != "") {
+			t.Errorf("X-Forwarded-Proto %q should be redirected", proto)
+		}
+	}
+}
+
+func TestHTTPSRedirectWithStatusPreservesMethod(t *testing.T) {
+	for _, status := range []int{http.StatusTemporaryRedirect, http.StatusPermanentRedirect} {
+		method := ""
+		tlsServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			method = r.Method
+		}))
+		_, port, _ := net.SplitHostPort(tlsServer.Listener.Addr().String())
+		ts := httptest.NewServer(HTTPSRedirectWithStatus(port, status))
+
+		req, _ := http.NewRequest(http.MethodPost, ts.URL+"/login", strings.NewReader("user=me"))
+		resp, err := tlsServer.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK || method != http.MethodPost {
+			t.Errorf("POST request redirected with status %v should stay a POST, got %q", status, method)
+		}
+		if resp.Request.Response == nil || resp.Request.Response.StatusCode != status {
+			t.Errorf("Redirect should use status %v", status)
+		}
+
+		ts.Close()
+		tlsServer.Close()
+	}
+}
+
+func TestHTTPSRedirectTarget(t *testing.T) {
+	h := HTTPSRedirect("443")
+	for target, location := range map[string]string{
+		"http://example.com/login?next=%2Fhome": "https://example.com:443/login?next=%2Fhome",
+		"http://[::1]:8080/login?next=1":        "https://[::1]:443/login?next=1",
+		"http://[2001:db8::1]/a%20b?q=1":        "https://[2001:db8::1]:443/a%20b?q=1",
+	} {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != http.StatusTemporaryRedirect || w.Header().Get("Location") != location {
+			t.Errorf("Request to %v should redirect to %v, got %v", target, location, w.Header().Get("Location"))
+		}
+	}
+}
+
+func TestHTTPSRedirectKeepsFragment(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "http://example.com:8080/a", nil)
+	req.URL.Fragment = "section"
+	w := httptest.NewRecorder()
+	HTTPSRedirect("443").ServeHTTP(w, req)
+	if location := w.Header().Get("Location"); location != "https://example.com:443/a#section" {
+		t.Errorf("Redirect should keep the fragment, got %v", location)
+	}
+}
+
+func TestHTTPSRedirectPermanent(t *testing.T) {
+	w := httptest.NewRecorder()
+	HTTPSRedirectPermanent("443").ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com/", nil))
+	if w.Code != http.StatusPermanentRedirect {
+		t.Errorf("Permanent redirect should use status %v, got %v", http.StatusPermanentRedirect, w.Code)
+	}
+}
+
+func TestHTTPSRedirectWithStatusRejectsNonRedirect(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Non-redirect status should panic")
+		}
+	}()
+	HTTPSRedirectWithStatus("443", http.StatusOK)
+}
+
 func TestDisallowingLongerPathsBasic(t *testing.T) {
 	checkNumber = 0
 	server := httptest.NewServer(DisallowLongerPaths("/", http.HandlerFunc(http.NotFound))((http.HandlerFunc(handlerTester))))
@@ -76,11 +282,496 @@ func TestDisallowingLongerPathsWithLongerURL(t *testing.T) {
 	}
 }
 
+func TestDisallowLongerPathsPattern(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/", DisallowLongerPaths("/", http.NotFoundHandler())(http.HandlerFunc(handlerTester)))
+	mux.Handle("GET /users/{id}", DisallowLongerPaths("/users/{id}", http.NotFoundHandler())(http.HandlerFunc(handlerTester)))
+	for path, expected := range map[string]int{
+		"/users/123": http.StatusOK,
+		"/":          http.StatusOK,
+		"/missing":   http.StatusNotFound,
+	} {
+		checkNumber = 0
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != expected {
+			t.Errorf("Request to %v should give %v, got %v", path, expected, w.Code)
+		}
+	}
+}
+
+func TestDisallowLongerPathsPrefix(t *testing.T) {
+	h := DisallowLongerPathsPrefix("/files/", http.NotFoundHandler())(http.HandlerFunc(handlerTester))
+	for path, expected := range map[string]int{
+		"/files/":                http.StatusOK,
+		"/files/report.pdf":      http.StatusOK,
+		"/files/2020/report.pdf": http.StatusNotFound,
+		"/files/2020/":           http.StatusNotFound,
+		"/filesystem":            http.StatusNotFound,
+		"/other/report.pdf":      http.StatusNotFound,
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != expected {
+			t.Errorf("Request to %v should give %v, got %v", path, expected, w.Code)
+		}
+	}
+}
+
+func TestAllowMethods(t *testing.T) {
+	checkNumber = 0
+	ts := httptest.NewServer(AllowMethods(http.MethodHead, http.MethodGet)(http.HandlerFunc(handlerTester)))
+	defer ts.Close()
+	client := ts.Client()
+
+	for _, method := range []string{http.MethodGet, http.MethodHead} {
+		req, _ := http.NewRequest(method, ts.URL, nil)
+		resp, err := client.Do(req)
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Errorf("%v request should be allowed", method)
+		}
+	}
+	if checkNumber != 2 {
+		t.Error("Allowed requests should reach the handler")
+	}
+
+	req, _ := http.NewRequest(http.MethodPost, ts.URL, nil)
+	resp, err := client.Do(req)
+	if err != nil || resp.StatusCode != http.StatusMethodNotAllowed || checkNumber != 2 {
+		t.Error("POST request should not be allowed")
+	}
+	if allow := resp.Header.Get("Allow"); allow != "GET, HEAD" {
+		t.Errorf("Allow header should list sorted methods, got %q", allow)
+	}
+}
+
+func TestRequestMethodSetsAllowHeader(t *testing.T) {
+	checkNumber = 0
+	ts := httptest.NewServer(RequestMethod(http.MethodPost)(http.HandlerFunc(handlerTester)))
+	defer ts.Close()
+
+	resp, err := ts.Client().Get(ts.URL)
+	if err != nil || resp.StatusCode != http.StatusMethodNotAllowed || checkNumber != 0 {
+		t.Error("GET request should not be allowed")
+	}
+	if allow := resp.Header.Get("Allow"); allow != "POST" {
+		t.Errorf("Allow header should be POST, got %q", allow)
+	}
+}
+
+func TestMethodRouter(t *testing.T) {
+	called := ""
+	methodHandler := func(name string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			called = name
+		})
+	}
+	handlers := map[string]http.Handler{
+		http.MethodGet:    methodHandler("get"),
+		http.MethodPost:   methodHandler("post"),
+		http.MethodPut:    methodHandler("put"),
+		http.MethodDelete: methodHandler("delete"),
+	}
+	ts := httptest.NewServer(MethodRouter(handlers)(nil))
+	defer ts.Close()
+	client := ts.Client()
+
+	for method, name := range map[string]string{http.MethodGet: "get", http.MethodPost: "post", http.MethodPut: "put", http.MethodDelete: "delete"} {
+		called = ""
+		req, _ := http.NewRequest(method, ts.URL, nil)
+		resp, err := client.Do(req)
+		if err != nil || resp.StatusCode != http.StatusOK || called != name {
+			t.Errorf("%v request should be handled by the %v handler, was handled by %q", method, name, called)
+		}
+	}
+
+	called = ""
+	req, _ := http.NewRequest(http.MethodPatch, ts.URL, nil)
+	resp, err := client.Do(req)
+	if err != nil || resp.StatusCode != http.StatusMethodNotAllowed || called != "" {
+		t.Error("PATCH request should not be allowed without a fallback")
+	}
+	if allow := resp.Header.Get("Allow"); allow != "DELETE, GET, OPTIONS, POST, PUT" {
+		t.Errorf("Unexpected Allow header %q", allow)
+	}
+
+	req, _ = http.NewRequest(http.MethodOptions, ts.URL, nil)
+	resp, err = client.Do(req)
+	if err != nil || resp.StatusCode != http.StatusNoContent || called != "" {
+		t.Error("OPTIONS request should be answered automatically")
+	}
+	if allow := resp.Header.Get("Allow"); allow != "DELETE, GET, OPTIONS, POST, PUT" {
+		t.Errorf("Unexpected Allow header %q", allow)
+	}
+}
+
+func TestMethodRouterFallback(t *testing.T) {
+	called := ""
+	h := MethodRouter(map[string]http.Handler{
+		http.MethodPost: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { called = "post" }),
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { called = "fallback" }))
+
+	for method, name := range map[string]string{http.MethodPost: "post", http.MethodGet: "fallback", http.MethodPatch: "fallback"} {
+		called = ""
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(method, "/", nil))
+		if w.Code != http.StatusOK || called != name {
+			t.Errorf("%v request should be handled by the %v handler, was handled by %q", method, name, called)
+		}
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodOptions, "/", nil))
+	if w.Code != http.StatusNoContent || w.Header().Get("Allow") != "OPTIONS, POST" {
+		t.Errorf("OPTIONS request should be answered automatically, got %v %q", w.Code, w.Header().Get("Allow"))
+	}
+}
+
+func TestAddHeaders(t *testing.T) {
+	headers := map[string]string{
+		"X-Content-Type-Options": "nosniff",
+		"X-Frame-Options":        "DENY",
+		"Referrer-Policy":        "no-referrer",
+	}
+	ts := httptest.NewServer(AddHeaders(headers)(http.HandlerFunc(handlerTester)))
+	defer ts.Close()
+
+	resp, err := ts.Client().Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, value := range headers {
+		if resp.Header.Get(name) != value {
+			t.Errorf("Header %v should be %q, got %q", name, value, resp.Header.Get(name))
+		}
+	}
+}
+
+func TestAddCookieWithFuncError(t *testing.T) {
+	checkNumber = 0
+	failing := func(w http.ResponseWriter, r *http.Request) error {
+		return fmt.Errorf("token generation failed")
+	}
+	ts := httptest.NewServer(AddCookieWithFunc("csrf", failing)(http.HandlerFunc(handlerTester)))
+	defer ts.Close()
+
+	resp, err := ts.Client().Get(ts.URL)
+	if err != nil || resp.StatusCode != http.StatusInternalServerError || checkNumber != 0 {
+		t.Error("Error adding the cookie should not reach the handler")
+	}
+}
+
+func TestAddCookieWithErrorHandler(t *testing.T) {
+	checkNumber = 0
+	failing := func(w http.ResponseWriter, r *http.Request) error {
+		return fmt.Errorf("token generation failed")
+	}
+	errorHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "custom", http.StatusServiceUnavailable)
+	})
+	ts := httptest.NewServer(AddCookieWithErrorHandler("csrf", failing, errorHandler)(http.HandlerFunc(handlerTester)))
+	defer ts.Close()
+
+	resp, err := ts.Client().Get(ts.URL)
+	if err != nil || resp.StatusCode != http.StatusServiceUnavailable || checkNumber != 0 {
+		t.Error("Error adding the cookie should call the error handler")
+	}
+}
+
+func TestAddCookieWithFuncSuccess(t *testing.T) {
+	checkNumber = 0
+	setCookie := func(w http.ResponseWriter, r *http.Request) error {
+		http.SetCookie(w, &http.Cookie{Name: "csrf", Value: "token"})
+		return nil
+	}
+	ts := httptest.NewServer(AddCookieWithFunc("csrf", setCookie)(http.HandlerFunc(handlerTester)))
+	defer ts.Close()
+
+	resp, err := ts.Client().Get(ts.URL)
+	if err != nil || resp.StatusCode != http.StatusOK || checkNumber != 1 || len(resp.Cookies()) != 1 {
+		t.Error("Cookie should be added before calling the handler")
+	}
+}
+
+func TestNotifyLogsStatus(t *testing.T) {
+	var buf bytes.Buffer
+	h := Notify(log.New(&buf, "", 0))(http.HandlerFunc(http.NotFound))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/missing", nil))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 log lines, got %q", buf.String())
+	}
+	if lines[0] != "Handling GET request at URL /missing" {
+		t.Errorf("Unexpected starting log line %q", lines[0])
+	}
+	if !strings.HasPrefix(lines[1], "GET request at URL /missing was handled with status 404 in ") {
+		t.Errorf("Finishing log line should include the status, got %q", lines[1])
+	}
+}
+
+func TestApplyIf(t *testing.T) {
+	isAPI := func(w http.ResponseWriter, r *http.Request) bool {
+		return strings.HasPrefix(r.URL.Path, "/api")
+	}
+	h := ApplyIf(isAPI, AddHeader("X-API", "true"))(http.HandlerFunc(handlerTester))
+
+	checkNumber = 0
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/users", nil))
+	if w.Header().Get("X-API") != "true" || checkNumber != 1 {
+		t.Error("Adapter should be applied to matching requests")
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/index.html", nil))
+	if w.Header().Get("X-API") != "" || checkNumber != 2 {
+		t.Error("Adapter should not be applied to other requests")
+	}
+}
+
+func TestOnPrefix(t *testing.T) {
+	h := OnPrefix("/api", AddHeader("X-API", "true"), AddHeader("X-Version", "1"))(http.HandlerFunc(handlerTester))
+	for path, applied := range map[string]bool{"/api": true, "/api/": true, "/api/x": true, "/apiary": false, "/": false, "/v1/api": false} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if (w.Header().Get("X-API") == "true" && w.Header().Get("X-Version") == "1") != applied {
+			t.Errorf("Adapters applied to %v should be %v", path, applied)
+		}
+	}
+
+	h = OnPrefix("/api/", AddHeader("X-API", "true"))(http.HandlerFunc(handlerTester))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/x", nil))
+	if w.Header().Get("X-API") != "true" {
+		t.Error("Prefix with a trailing slash should match paths below it")
+	}
+}
+
+func TestHandlerCheckerCombinators(t *testing.T) {
+	calls := 0
+	checker := func(result bool) HandlerChecker {
+		return func(w http.ResponseWriter, r *http.Request) bool {
+			calls++
+			return result
+		}
+	}
+	yes, no := checker(true), checker(false)
+	tests := []struct {
+		name     string
+		check    HandlerChecker
+		expected bool
+		calls    int
+	}{
+		{"And()", And(), true, 0},
+		{"And(true, true)", And(yes, yes), true, 2},
+		{"And(true, false)", And(yes, no), false, 2},
+		{"And(false, true)", And(no, yes), false, 1},
+		{"And(false, false)", And(no, no), false, 1},
+		{"Or()", Or(), false, 0},
+		{"Or(true, true)", Or(yes, yes), true, 1},
+		{"Or(true, false)", Or(yes, no), true, 1},
+		{"Or(false, true)", Or(no, yes), true, 2},
+		{"Or(false, false)", Or(no, no), false, 2},
+		{"Not(true)", Not(yes), false, 1},
+		{"Not(false)", Not(no), true, 1},
+		{"And(Or(false, true), Not(false))", And(Or(no, yes), Not(no)), true, 3},
+	}
+	for _, test := range tests {
+		calls = 0
+		result := test.check(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		if result != test.expected || calls != test.calls {
+			t.Errorf("%v should be %v after %v checks, got %v after %v checks", test.name, test.expected, test.calls, result, calls)
+		}
+	}
+}
+
+func TestMethodNotAllowedDefault(t *testing.T) {
+	checkNumber = 0
+	h := GetAndOtherRequest(http.HandlerFunc(handlerTester), http.MethodPost)(http.HandlerFunc(handlerTester))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/", nil))
+
+	if w.Code != http.StatusMethodNotAllowed || checkNumber != 0 {
+		t.Error("PUT request should not be allowed")
+	}
+	if w.Body.String() != "Request method not allowed\n" || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		t.Errorf("Default response should be plain text, got %q", w.Body.String())
+	}
+	if allow := w.Header().Get("Allow"); allow != "GET, POST" {
+		t.Errorf("Unexpected Allow header %q", allow)
+	}
+}
+
+func TestMethodNotAllowedCustomHandler(t *testing.T) {
+	allowSeen := ""
+	jsonHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		allowSeen = w.Header().Get("Allow")
+		if r.Header.Get("Accept") == "application/json" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			w.Write([]byte(`{"error":"method not allowed"}`))
+			return
+		}
+		methodNotAllowedHandler.ServeHTTP(w, r)
+	})
+
+	for _, h := range []http.Handler{
+		AllowMethodsWithHandler(jsonHandler, http.MethodGet, http.MethodPost)(http.HandlerFunc(handlerTester)),
+		GetAndOtherRequestWithHandler(http.HandlerFunc(handlerTester), http.MethodPost, jsonHandler)(http.HandlerFunc(handlerTester)),
+	} {
+		allowSeen = ""
+		req := httptest.NewRequest(http.MethodDelete, "/", nil)
+		req.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+
+		if w.Code != http.StatusMethodNotAllowed || w.Body.String() != `{"error":"method not allowed"}` {
+			t.Errorf("Custom handler should give a JSON error, got %q", w.Body.String())
+		}
+		if allowSeen != "GET, POST" {
+			t.Errorf("Allow header should be set before the custom handler runs, got %q", allowSeen)
+		}
+	}
+}
+
+func TestChain(t *testing.T) {
+	order := ""
+	record := func(name string) Adapter {
+		return func(h http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				order += name
+				h.ServeHTTP(w, r)
+			})
+		}
+	}
+	base := NewChain(record("a"), record("b"))
+	derived := base.Append(record("c"))
+	other := derived.Append(record("d"))
+	extended := base.Extend(NewChain(record("e")))
+
+	for chain, expected := range map[*Chain]string{&base: "ab", &derived: "abc", &other: "abcd", &extended: "abe"} {
+		order = ""
+		chain.Then(http.HandlerFunc(handlerTester)).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		if order != expected {
+			t.Errorf("Chain should call adapters in order %v, got %v", expected, order)
+		}
+	}
+}
+
 func handlerTester(w http.ResponseWriter, r *http.Request) {
 	log.Println("Handling test request")
 	checkNumber++
 }
 
+func TestAdaptWithoutAdapters(t *testing.T) {
+	h := http.HandlerFunc(handlerTester)
+	if reflect.ValueOf(Adapt(h)).Pointer() != reflect.ValueOf(h).Pointer() {
+		t.Error("Adapt without adapters should return the original handler")
+	}
+}
+
+func TestAdaptFunc(t *testing.T) {
+	adapters := []Adapter{AddHeader("X-First", "1"), AddHeader("X-Second", "2")}
+	adapted := httptest.NewRecorder()
+	AdaptFunc(handlerTester, adapters...).ServeHTTP(adapted, httptest.NewRequest(http.MethodGet, "/", nil))
+	expected := httptest.NewRecorder()
+	Adapt(http.HandlerFunc(handlerTester), adapters...).ServeHTTP(expected, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if !reflect.DeepEqual(adapted.Header(), expected.Header()) || adapted.Code != expected.Code {
+		t.Errorf("AdaptFunc should be the same as Adapt, got %v and %v", adapted.Header(), expected.Header())
+	}
+}
+
+func TestSetCookie(t *testing.T) {
+	cookie := &http.Cookie{Name: "theme", Value: "dark", Path: "/", Secure: true, HttpOnly: true, SameSite: http.SameSiteStrictMode}
+	a := SetCookie(cookie)
+	cookie.Value = "light"
+
+	w := httptest.NewRecorder()
+	a(http.HandlerFunc(handlerTester)).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if expected := "theme=dark; Path=/; HttpOnly; Secure; SameSite=Strict"; w.Header().Get("Set-Cookie") != expected {
+		t.Errorf("Expected Set-Cookie %q, got %q", expected, w.Header().Get("Set-Cookie"))
+	}
+}
+
+func TestClearCookie(t *testing.T) {
+	w := httptest.NewRecorder()
+	ClearCookie("session")(http.HandlerFunc(handlerTester)).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if expected := "session=; Path=/; Expires=Thu, 01 Jan 1970 00:00:00 GMT; Max-Age=0"; w.Header().Get("Set-Cookie") != expected {
+		t.Errorf("Expected Set-Cookie %q, got %q", expected, w.Header().Get("Set-Cookie"))
+	}
+}
+
+func TestSetLogger(t *testing.T) {
+	var buf bytes.Buffer
+	SetLogger(log.New(&buf, "", 0))
+	defer SetLogger(nil)
+
+	h := DisallowLongerPaths("/login", http.NotFoundHandler())(http.HandlerFunc(handlerTester))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/login/extra", nil))
+	if !strings.Contains(buf.String(), "Handler expects URL /login but received a request at /login/extra") {
+		t.Errorf("Custom logger should get the message, got %q", buf.String())
+	}
+}
+
+func TestSkip(t *testing.T) {
+	h := Skip(BasicAuth("admin", BasicAuthCredentials("user", "pass")), "/healthz", "/static/*")(http.HandlerFunc(handlerTester))
+	for path, expected := range map[string]int{
+		"/healthz":       http.StatusOK,
+		"/static/app.js": http.StatusOK,
+		"/healthz/deep":  http.StatusUnauthorized,
+		"/admin":         http.StatusUnauthorized,
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != expected {
+			t.Errorf("Request to %v should get %v, got %v", path, expected, w.Code)
+		}
+	}
+}
+
+func TestFeatureFlag(t *testing.T) {
+	enabled := false
+	disabledCalled := false
+	disabled := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		disabledCalled = true
+	})
+	h := FeatureFlag("beta", func(r *http.Request) bool { return enabled }, disabled)(http.HandlerFunc(handlerTester))
+
+	checkNumber = 0
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if checkNumber != 0 || !disabledCalled {
+		t.Error("Disabled feature should be served by the disabled handler")
+	}
+
+	enabled, disabledCalled = true, false
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if checkNumber != 1 || disabledCalled {
+		t.Error("Enabled feature should be served by the handler")
+	}
+}
+
+func TestFeatureFlagDefaultNotFound(t *testing.T) {
+	checkNumber = 0
+	h := FeatureFlag("beta", func(r *http.Request) bool {
+		_, err := r.Cookie("beta")
+		return err == nil
+	}, nil)(http.HandlerFunc(handlerTester))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusNotFound || checkNumber != 0 {
+		t.Error("Disabled feature should be hidden with a 404")
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(&http.Cookie{Name: "beta", Value: "1"})
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if checkNumber != 1 {
+		t.Error("Request with the cookie should see the feature")
+	}
+}
+
 func handlerPanic(w http.ResponseWriter, r *http.Request) {
 	panic("Panic should rollback")
 }
@@ -0,0 +1,203 @@
+package adaptd
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// BasicAuth adapter checks the HTTP Basic credentials of the request with the given function.
+// If the credentials are missing, malformed, or the check fails, a http.StatusUnauthorized error
+// is given along with a WWW-Authenticate header for the realm.
+func BasicAuth(realm string, check func(user, pass string) bool) Adapter {
+	challenge := fmt.Sprintf("Basic realm=%q", realm)
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, pass, ok := r.BasicAuth()
+			if !ok || !check(user, pass) {
+				w.Header().Add("WWW-Authenticate", challenge)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// BearerAuth adapter checks the bearer token in the Authorization header of the request with the given function.
+// If the token is missing or the check fails, a http.StatusUnauthorized error
+// is given along with a WWW-Authenticate header for the realm.
+func BearerAuth(realm string, check func(token string) bool) Adapter {
+	challenge := fmt.Sprintf("Bearer realm=%q", realm)
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+			if !strings.EqualFold(scheme, "Bearer") || token == "" || !check(token) {
+				w.Header().Add("WWW-Authenticate", challenge)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// AnyAuth adapter accepts requests that pass any one of the auth adapters, like BasicAuth and BearerAuth,
+// which are tried in order. If none pass, the response of the first is given with the
+// WWW-Authenticate challenges of all of them, so clients know every scheme that is accepted.
+func AnyAuth(adapters ...Adapter) Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var first *authAttempt
+			var challenges []string
+			for _, a := range adapters {
+				attempt := &authAttempt{header: make(http.Header), status: http.StatusOK}
+				a(http.HandlerFunc(func(_ http.ResponseWriter, passed *http.Request) {
+					attempt.passed = passed
+				})).ServeHTTP(attempt, r)
+				if attempt.passed != nil {
+					for name, values := range attempt.header {
+						w.Header()[name] = append(w.Header()[name], values...)
+					}
+					h.ServeHTTP(w, attempt.passed)
+					return
+				}
+				if first == nil {
+					first = attempt
+				}
+				challenges = append(challenges, attempt.header.Values("WWW-Authenticate")...)
+			}
+			if first == nil {
+				h.ServeHTTP(w, r)
+				return
+			}
+			for name, values := range first.header {
+				w.Header()[name] = values
+			}
+			w.Header().Del("WWW-Authenticate")
+			for _, challenge := range challenges {
+				w.Header().Add("WWW-Authenticate", challenge)
+			}
+			w.WriteHeader(first.status)
+			w.Write(first.body.Bytes())
+		})
+	}
+}
+
+// authAttempt records the response of an auth adapter and the request it passed on, if any.
+type authAttempt struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+	passed *http.Request
+}
+
+func (a *authAttempt) Header() http.Header {
+	return a.header
+}
+
+func (a *authAttempt) WriteHeader(code int) {
+	a.status = code
+}
+
+func (a *authAttempt) Write(b []byte) (int, error) {
+	return a.body.Write(b)
+}
+
+// BasicAuthCredentials returns a check function for BasicAuth that only accepts the given user and password.
+// The comparison is done in constant time to avoid timing attacks.
+func BasicAuthCredentials(user, pass string) func(string, string) bool {
+	return func(u, p string) bool {
+		// Both are compared every time so the response time does not reveal which one was wrong.
+		userMatch := subtle.ConstantTimeCompare([]byte(u), []byte(user))
+		passMatch := subtle.ConstantTimeCompare([]byte(p), []byte(pass))
+		return userMatch&passMatch == 1
+	}
+}
+
+// VerifyCSRF adapter verifies the CSRF token of requests with unsafe methods (POST, PUT, PATCH, DELETE, etc.).
+// The token in the cookie must match the token in the header or, if the header is not present,
+// the form field with the same name as the header. Otherwise, a http.StatusForbidden error is given.
+// Requests with safe methods (GET, HEAD, OPTIONS, TRACE) are not checked.
+func VerifyCSRF(cookieName, headerName string) Adapter {
+	return VerifyCSRFWithOptions(cookieName, headerName, CSRFOptions{})
+}
+
+// CSRFOptions are the options for VerifyCSRFWithOptions.
+type CSRFOptions struct {
+	// Exempt returns true for requests that are not checked, e.g. requests authenticated with a bearer token
+	// instead of a cookie, which are not subject to CSRF. Exempt requests must still be authenticated,
+	// e.g. by BearerAuth, or anyone can skip the check. The default exempts no requests.
+	Exempt func(*http.Request) bool
+}
+
+// VerifyCSRFWithOptions adapter is like VerifyCSRF, but is configured with the options.
+func VerifyCSRFWithOptions(cookieName, headerName string, opts CSRFOptions) Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if isSafeMethod(r.Method) || (opts.Exempt != nil && opts.Exempt(r)) {
+				h.ServeHTTP(w, r)
+				return
+			}
+			cookie, err := r.Cookie(cookieName)
+			if err != nil || cookie.Value == "" {
+				http.Error(w, "CSRF token missing", http.StatusForbidden)
+				return
+			}
+			token := r.Header.Get(headerName)
+			if token == "" {
+				token = r.PostFormValue(headerName)
+			}
+			if subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(token)) != 1 {
+				http.Error(w, "CSRF token invalid", http.StatusForbidden)
+				return
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+// Roles are the roles of an authenticated user.
+type Roles interface {
+	HasRole(role string) bool
+}
+
+// RoleList is a list of roles that implements Roles.
+type RoleList []string
+
+// HasRole reports whether the role is in the list.
+func (l RoleList) HasRole(role string) bool {
+	for _, r := range l {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// RequireRole adapter only calls the handler if the user on the request's context has the role.
+// The roles of the user are retrieved with userFromCtx, which should return false if there is no authenticated user.
+// Otherwise, the forbidden handler is called. If forbidden is nil, a http.StatusForbidden error is given.
+func RequireRole(role string, userFromCtx func(context.Context) (Roles, bool), forbidden http.Handler) Adapter {
+	if forbidden == nil {
+		forbidden = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		})
+	}
+	hasRole := func(w http.ResponseWriter, r *http.Request) bool {
+		roles, ok := userFromCtx(r.Context())
+		return ok && roles != nil && roles.HasRole(role)
+	}
+	return OnCheck(hasRole, forbidden, fmt.Sprintf("User does not have role %v", role))
+}
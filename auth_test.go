@@ -0,0 +1,240 @@
+package adaptd
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestBasicAuthMissingHeader(t *testing.T) {
+	checkNumber = 0
+	ts := httptest.NewServer(BasicAuth("admin", BasicAuthCredentials("user", "pass"))(http.HandlerFunc(handlerTester)))
+	defer ts.Close()
+
+	resp, err := ts.Client().Get(ts.URL)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || checkNumber != 0 {
+		t.Error("Request without credentials should not be authorized")
+	}
+	if resp.Header.Get("WWW-Authenticate") != `Basic realm="admin"` {
+		t.Errorf("Unexpected WWW-Authenticate header: %v", resp.Header.Get("WWW-Authenticate"))
+	}
+}
+
+func TestBasicAuthMalformedHeader(t *testing.T) {
+	checkNumber = 0
+	ts := httptest.NewServer(BasicAuth("admin", BasicAuthCredentials("user", "pass"))(http.HandlerFunc(handlerTester)))
+	defer ts.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+	req.Header.Set("Authorization", "Basic not*base64!")
+	resp, err := ts.Client().Do(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || checkNumber != 0 {
+		t.Error("Request with malformed credentials should not be authorized")
+	}
+}
+
+func TestBasicAuthWrongCredentials(t *testing.T) {
+	checkNumber = 0
+	ts := httptest.NewServer(BasicAuth("admin", BasicAuthCredentials("user", "pass"))(http.HandlerFunc(handlerTester)))
+	defer ts.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+	req.SetBasicAuth("user", "wrong")
+	resp, err := ts.Client().Do(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || checkNumber != 0 {
+		t.Error("Request with wrong credentials should not be authorized")
+	}
+}
+
+func TestBasicAuthSuccess(t *testing.T) {
+	checkNumber = 0
+	ts := httptest.NewServer(BasicAuth("admin", BasicAuthCredentials("user", "pass"))(http.HandlerFunc(handlerTester)))
+	defer ts.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+	req.SetBasicAuth("user", "pass")
+	resp, err := ts.Client().Do(req)
+	if err != nil || resp.StatusCode != http.StatusOK || checkNumber != 1 {
+		t.Error("Request with correct credentials should reach the handler")
+	}
+}
+
+func TestVerifyCSRFMatchingTokens(t *testing.T) {
+	checkNumber = 0
+	h := VerifyCSRF("csrf", "X-CSRF-Token")(http.HandlerFunc(handlerTester))
+
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.AddCookie(&http.Cookie{Name: "csrf", Value: "token"})
+	req.Header.Set("X-CSRF-Token", "token")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || checkNumber != 1 {
+		t.Error("Request with matching CSRF tokens should be allowed")
+	}
+
+	form := url.Values{"X-CSRF-Token": {"token"}}
+	req = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: "csrf", Value: "token"})
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || checkNumber != 2 {
+		t.Error("Request with matching CSRF token in the form should be allowed")
+	}
+}
+
+func TestVerifyCSRFMismatchedTokens(t *testing.T) {
+	checkNumber = 0
+	h := VerifyCSRF("csrf", "X-CSRF-Token")(http.HandlerFunc(handlerTester))
+
+	req := httptest.NewRequest(http.MethodDelete, "/", nil)
+	req.AddCookie(&http.Cookie{Name: "csrf", Value: "token"})
+	req.Header.Set("X-CSRF-Token", "other")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden || checkNumber != 0 {
+		t.Error("Request with mismatched CSRF tokens should be forbidden")
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/", nil)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden || checkNumber != 0 {
+		t.Error("Request without CSRF tokens should be forbidden")
+	}
+}
+
+func TestVerifyCSRFSafeMethod(t *testing.T) {
+	checkNumber = 0
+	h := VerifyCSRF("csrf", "X-CSRF-Token")(http.HandlerFunc(handlerTester))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusOK || checkNumber != 1 {
+		t.Error("GET request without CSRF tokens should be allowed")
+	}
+}
+
+func TestVerifyCSRFExempt(t *testing.T) {
+	h := VerifyCSRFWithOptions("csrf", "X-CSRF-Token", CSRFOptions{
+		Exempt: func(r *http.Request) bool {
+			scheme, _, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+			return strings.EqualFold(scheme, "Bearer")
+		},
+	})(http.HandlerFunc(handlerTester))
+
+	checkNumber = 0
+	req := httptest.NewRequest(http.MethodPut, "/", nil)
+	req.AddCookie(&http.Cookie{Name: "csrf", Value: "token"})
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden || checkNumber != 0 {
+		t.Errorf("Cookie request without a CSRF token should be forbidden, got %v", w.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodDelete, "/", nil)
+	req.Header.Set("Authorization", "Bearer abc123")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || checkNumber != 1 {
+		t.Errorf("Exempt bearer request without a CSRF token should be allowed, got %v", w.Code)
+	}
+}
+
+type testUserKey struct{}
+
+func testUserRoles(ctx context.Context) (Roles, bool) {
+	roles, ok := ctx.Value(testUserKey{}).(RoleList)
+	return roles, ok
+}
+
+func requestWithRoles(roles RoleList) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "/admin", nil)
+	if roles != nil {
+		req = req.WithContext(context.WithValue(req.Context(), testUserKey{}, roles))
+	}
+	return req
+}
+
+func TestRequireRoleAuthorized(t *testing.T) {
+	checkNumber = 0
+	h := RequireRole("admin", testUserRoles, nil)(http.HandlerFunc(handlerTester))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, requestWithRoles(RoleList{"user", "admin"}))
+	if w.Code != http.StatusOK || checkNumber != 1 {
+		t.Error("User with the role should be allowed")
+	}
+}
+
+func TestRequireRoleUnauthorized(t *testing.T) {
+	checkNumber = 0
+	h := RequireRole("admin", testUserRoles, nil)(http.HandlerFunc(handlerTester))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, requestWithRoles(RoleList{"user"}))
+	if w.Code != http.StatusForbidden || checkNumber != 0 {
+		t.Error("User without the role should be forbidden")
+	}
+}
+
+func TestRequireRoleUnauthenticated(t *testing.T) {
+	checkNumber = 0
+	h := RequireRole("admin", testUserRoles, nil)(http.HandlerFunc(handlerTester))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, requestWithRoles(nil))
+	if w.Code != http.StatusForbidden || checkNumber != 0 {
+		t.Error("Request without a user should be forbidden")
+	}
+}
+
+func TestBearerAuth(t *testing.T) {
+	h := BearerAuth("api", func(token string) bool { return token == "secret" })(http.HandlerFunc(handlerTester))
+	for authorization, expected := range map[string]int{
+		"Bearer secret": http.StatusOK,
+		"bearer secret": http.StatusOK,
+		"Bearer wrong":  http.StatusUnauthorized,
+		"Basic secret":  http.StatusUnauthorized,
+		"":              http.StatusUnauthorized,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", authorization)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != expected {
+			t.Errorf("Authorization %q should get %v, got %v", authorization, expected, w.Code)
+		}
+		if expected == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") != `Bearer realm="api"` {
+			t.Errorf("Unauthorized request should get a Bearer challenge, got %q", w.Header().Get("WWW-Authenticate"))
+		}
+	}
+}
+
+func TestAnyAuthChallenges(t *testing.T) {
+	h := AnyAuth(
+		BasicAuth("site", BasicAuthCredentials("user", "pass")),
+		BearerAuth("api", func(token string) bool { return token == "secret" }),
+	)(http.HandlerFunc(handlerTester))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	challenges := w.Header().Values("WWW-Authenticate")
+	if w.Code != http.StatusUnauthorized || len(challenges) != 2 || challenges[0] != `Basic realm="site"` || challenges[1] != `Bearer realm="api"` {
+		t.Errorf("Unauthorized request should get both challenges, got %v %v", w.Code, challenges)
+	}
+
+	for _, authorize := range []func(*http.Request){
+		func(r *http.Request) { r.SetBasicAuth("user", "pass") },
+		func(r *http.Request) { r.Header.Set("Authorization", "Bearer secret") },
+	} {
+		checkNumber = 0
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		authorize(req)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != http.StatusOK || checkNumber != 1 || w.Header().Get("WWW-Authenticate") != "" {
+			t.Errorf("Request passing either scheme should be handled, got %v", w.Code)
+		}
+	}
+}
@@ -0,0 +1,342 @@
+package adaptd
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// MaxBodyBytes adapter limits the size of the request body to n bytes.
+// Requests whose Content-Length is larger are given a http.StatusRequestEntityTooLarge error without calling the handler.
+// Otherwise, reading past the limit returns an error to the handler. If the handler does not respond
+// after reading past the limit, a http.StatusRequestEntityTooLarge error is given.
+func MaxBodyBytes(n int64) Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > n {
+				http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+				return
+			}
+			if r.Body == nil {
+				h.ServeHTTP(w, r)
+				return
+			}
+			sr := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			body := &limitedBody{ReadCloser: http.MaxBytesReader(sr, r.Body, n)}
+			r2 := new(http.Request)
+			*r2 = *r
+			r2.Body = body
+			h.ServeHTTP(sr, r2)
+			if body.exceeded && !sr.wroteHeader {
+				http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+			}
+		})
+	}
+}
+
+// RequireContentLength adapter requires requests with a body to give its size in the Content-Length header,
+// so chunked uploads of unknown length are rejected before the body is read.
+// Requests without one are given a http.StatusLengthRequired error, and requests whose Content-Length is
+// larger than max are given a http.StatusRequestEntityTooLarge error.
+func RequireContentLength(max int64) Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength < 0 {
+				http.Error(w, http.StatusText(http.StatusLengthRequired), http.StatusLengthRequired)
+				return
+			}
+			if r.ContentLength > max {
+				http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+				return
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// limitedBody records whether the limit of a http.MaxBytesReader was reached.
+type limitedBody struct {
+	io.ReadCloser
+	exceeded bool
+}
+
+func (l *limitedBody) Read(b []byte) (int, error) {
+	n, err := l.ReadCloser.Read(b)
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		l.exceeded = true
+	}
+	return n, err
+}
+
+// DefaultDecompressLimit is the largest decompressed request body, in bytes, that DecompressRequest lets the handler read.
+const DefaultDecompressLimit = 10 << 20
+
+// DecompressRequest adapter decompresses request bodies with a Content-Encoding of gzip or deflate
+// so the handler reads the uncompressed body. The Content-Encoding and Content-Length headers are removed.
+// Bodies that cannot be decompressed are given a http.StatusBadRequest error and
+// other encodings are given a http.StatusUnsupportedMediaType error.
+// The decompressed body is limited to DefaultDecompressLimit bytes, as a small compressed body can expand
+// to fill the memory of the server.
+func DecompressRequest() Adapter {
+	return DecompressRequestWithLimit(DefaultDecompressLimit)
+}
+
+// DecompressRequestWithLimit adapter is like DecompressRequest, but the decompressed body is limited to limit bytes.
+// Reading past the limit returns an error to the handler. If the handler does not respond after reading past the limit,
+// a http.StatusRequestEntityTooLarge error is given.
+func DecompressRequestWithLimit(limit int64) Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+			if encoding == "" || encoding == "identity" || r.Body == nil || r.Body == http.NoBody {
+				h.ServeHTTP(w, r)
+				return
+			}
+			var body io.Reader
+			var err error
+			switch encoding {
+			case "gzip", "x-gzip":
+				body, err = gzip.NewReader(r.Body)
+			case "deflate":
+				body, err = newDeflateReader(r.Body)
+			default:
+				http.Error(w, "Content-Encoding not supported", http.StatusUnsupportedMediaType)
+				return
+			}
+			if err != nil {
+				http.Error(w, "Malformed request body", http.StatusBadRequest)
+				return
+			}
+			sr := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			decoded := &decodedBody{Reader: body, Closer: r.Body}
+			limited := &limitedBody{ReadCloser: http.MaxBytesReader(sr, decoded, limit)}
+			r2 := r.Clone(r.Context())
+			r2.Body = limited
+			r2.Header.Del("Content-Encoding")
+			r2.Header.Del("Content-Length")
+			r2.ContentLength = -1
+			h.ServeHTTP(sr, r2)
+			if limited.exceeded && !sr.wroteHeader {
+				http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+			} else if decoded.failed && !sr.wroteHeader {
+				http.Error(w, "Malformed request body", http.StatusBadRequest)
+			}
+		})
+	}
+}
+
+// newDeflateReader reads a deflate encoded body. The deflate encoding should use the zlib format,
+// but some clients send raw deflate data so that is also accepted.
+func newDeflateReader(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	header, err := br.Peek(2)
+	if err != nil {
+		return nil, err
+	}
+	// A zlib header uses the deflate method and is a multiple of 31.
+	if header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		return zlib.NewReader(br)
+	}
+	return flate.NewReader(br), nil
+}
+
+// decodedBody records whether there was an error decoding the body.
+type decodedBody struct {
+	io.Reader
+	io.Closer
+	failed bool
+}
+
+func (d *decodedBody) Read(b []byte) (int, error) {
+	n, err := d.Reader.Read(b)
+	if err != nil && err != io.EOF {
+		d.failed = true
+	}
+	return n, err
+}
+
+// RequireContentType adapter only allows request bodies with one of the given media types.
+// Parameters like charset are ignored, so application/json; charset=utf-8 is allowed by application/json.
+// POST, PUT, and PATCH requests, and any other request with a body, with a different or missing
+// Content-Type are given a http.StatusUnsupportedMediaType error.
+func RequireContentType(types ...string) Adapter {
+	allowed := make(map[string]bool, len(types))
+	for _, t := range types {
+		allowed[strings.ToLower(t)] = true
+	}
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !hasBody(r) {
+				h.ServeHTTP(w, r)
+				return
+			}
+			mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			if err != nil || !allowed[mediaType] {
+				http.Error(w, "Content-Type not supported", http.StatusUnsupportedMediaType)
+				return
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// hasBody reports whether the request is expected to have a body.
+func hasBody(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch:
+		return true
+	}
+	return r.ContentLength != 0
+}
+
+// defaultJSONMaxBytes is the largest JSON body that is decoded by default.
+const defaultJSONMaxBytes = 1 << 20
+
+// JSONOptions configure DecodeJSONWithOptions.
+type JSONOptions struct {
+	// AllowUnknownFields allows fields in the body that are not in the target. The default is to reject them.
+	AllowUnknownFields bool
+	// MaxBytes is the largest body that is decoded. The default is 1 MB.
+	MaxBytes int64
+}
+
+// DecodeJSON adapter decodes request bodies with a JSON Content-Type into a new value from newTarget,
+// which should return a pointer, e.g. func() any { return &User{} }. Fields that are not in the target are rejected.
+// Bodies that cannot be decoded are given a http.StatusBadRequest error and bodies larger than 1 MB
+// are given a http.StatusRequestEntityTooLarge error. Requests without a JSON body are passed to the handler as is.
+// The decoded value can be retrieved by handlers with DecodedBodyFromContext.
+func DecodeJSON(newTarget func() any) Adapter {
+	return DecodeJSONWithOptions(newTarget, JSONOptions{})
+}
+
+// DecodeJSONWithOptions is like DecodeJSON, but decoding is configured with the options.
+func DecodeJSONWithOptions(newTarget func() any, opts JSONOptions) Adapter {
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = defaultJSONMaxBytes
+	}
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !hasJSONBody(r) {
+				h.ServeHTTP(w, r)
+				return
+			}
+			target := newTarget()
+			dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, opts.MaxBytes))
+			if !opts.AllowUnknownFields {
+				dec.DisallowUnknownFields()
+			}
+			err := dec.Decode(target)
+			var maxBytesErr *http.MaxBytesError
+			if err == nil {
+				if _, tokenErr := dec.Token(); errors.As(tokenErr, &maxBytesErr) {
+					err = tokenErr
+				} else if tokenErr != io.EOF {
+					err = errors.New("body must contain a single JSON value")
+				}
+			}
+			switch {
+			case errors.As(err, &maxBytesErr):
+				http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+				return
+			case err != nil:
+				http.Error(w, "Malformed JSON body: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), decodedBodyKey, target)))
+		})
+	}
+}
+
+// DecodedBodyFromContext returns the value stored on the context by DecodeJSON.
+func DecodedBodyFromContext(ctx context.Context) (any, bool) {
+	v := ctx.Value(decodedBodyKey)
+	return v, v != nil
+}
+
+// ValidateJSONSchema adapter validates the bodies of requests against the schema before calling the handler.
+// Bodies that are not a single JSON value are given a http.StatusBadRequest error, bodies that do not match the schema
+// are given a http.StatusUnprocessableEntity error listing each validation error, and bodies larger than 1 MB
+// are given a http.StatusRequestEntityTooLarge error. Every request with a body is validated, whatever its Content-Type.
+// The body is buffered, so the handler can still read it.
+func ValidateJSONSchema(schema *jsonschema.Schema) Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Body == nil || r.Body == http.NoBody || !hasBody(r) {
+				h.ServeHTTP(w, r)
+				return
+			}
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, defaultJSONMaxBytes))
+			r.Body.Close()
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+				return
+			} else if err != nil {
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				return
+			}
+			dec := json.NewDecoder(bytes.NewReader(body))
+			dec.UseNumber()
+			var v any
+			err = dec.Decode(&v)
+			if _, tokenErr := dec.Token(); err == nil && tokenErr != io.EOF {
+				err = errors.New("body must contain a single JSON value")
+			}
+			if err != nil {
+				http.Error(w, "Malformed JSON body: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := schema.Validate(v); err != nil {
+				var ve *jsonschema.ValidationError
+				if !errors.As(err, &ve) {
+					packageLogger().Printf("Failed to validate the body of %v request at URL %v: %v\n", r.Method, r.URL, err)
+					http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+					return
+				}
+				http.Error(w, "Body does not match the schema:\n"+strings.Join(schemaErrors(ve), "\n"), http.StatusUnprocessableEntity)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			r.ContentLength = int64(len(body))
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// schemaErrors returns the causes of the validation error that have no causes of their own,
+// each with the location of the value in the body, e.g. "/items/0: expected string, but got number".
+func schemaErrors(ve *jsonschema.ValidationError) []string {
+	if len(ve.Causes) == 0 {
+		location := ve.InstanceLocation
+		if location == "" {
+			location = "/"
+		}
+		return []string{location + ": " + ve.Message}
+	}
+	var errs []string
+	for _, cause := range ve.Causes {
+		errs = append(errs, schemaErrors(cause)...)
+	}
+	return errs
+}
+
+// hasJSONBody reports whether the request has a body with a JSON media type, like application/json or application/problem+json.
+func hasJSONBody(r *http.Request) bool {
+	if r.Body == nil || r.Body == http.NoBody || !hasBody(r) {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && (mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"))
+}
@@ -0,0 +1,304 @@
+package adaptd
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+func bodyReader(body *string, readErr *error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := io.ReadAll(r.Body)
+		*body, *readErr = string(b), err
+	})
+}
+
+func TestMaxBodyBytesSmallBody(t *testing.T) {
+	var body string
+	var readErr error
+	ts := httptest.NewServer(MaxBodyBytes(10)(bodyReader(&body, &readErr)))
+	defer ts.Close()
+
+	resp, err := ts.Client().Post(ts.URL, "text/plain", strings.NewReader("small"))
+	if err != nil || resp.StatusCode != http.StatusOK || body != "small" || readErr != nil {
+		t.Error("Body under the limit should be accepted")
+	}
+}
+
+func TestMaxBodyBytesContentLength(t *testing.T) {
+	checkNumber = 0
+	ts := httptest.NewServer(MaxBodyBytes(10)(http.HandlerFunc(handlerTester)))
+	defer ts.Close()
+
+	resp, err := ts.Client().Post(ts.URL, "text/plain", strings.NewReader("this body is too large"))
+	if err != nil || resp.StatusCode != http.StatusRequestEntityTooLarge || checkNumber != 0 {
+		t.Error("Body over the limit should be rejected before calling the handler")
+	}
+}
+
+func TestMaxBodyBytesUnknownLength(t *testing.T) {
+	var body string
+	var readErr error
+	ts := httptest.NewServer(MaxBodyBytes(10)(bodyReader(&body, &readErr)))
+	defer ts.Close()
+
+	// Wrapping the reader hides the length so the body is sent chunked.
+	resp, err := ts.Client().Post(ts.URL, "text/plain", io.MultiReader(strings.NewReader("this body is too large")))
+	if err != nil || resp.StatusCode != http.StatusRequestEntityTooLarge || readErr == nil {
+		t.Error("Reading a body over the limit should give an error")
+	}
+}
+
+func TestMaxBodyBytesKeepsCallersRequest(t *testing.T) {
+	var body string
+	var readErr error
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("small"))
+	original := req.Body
+	MaxBodyBytes(10)(bodyReader(&body, &readErr)).ServeHTTP(httptest.NewRecorder(), req)
+	if body != "small" || req.Body != original {
+		t.Error("Body should be limited on a copy of the request")
+	}
+}
+
+func TestRequireContentLength(t *testing.T) {
+	h := RequireContentLength(10)(http.HandlerFunc(handlerTester))
+	for name, tc := range map[string]struct {
+		contentLength int64
+		expected      int
+	}{
+		"missing":   {-1, http.StatusLengthRequired},
+		"oversized": {11, http.StatusRequestEntityTooLarge},
+		"valid":     {10, http.StatusOK},
+		"empty":     {0, http.StatusOK},
+	} {
+		checkNumber = 0
+		req := httptest.NewRequest(http.MethodPut, "/upload", strings.NewReader("0123456789a"))
+		req.ContentLength = tc.contentLength
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != tc.expected || (tc.expected == http.StatusOK) != (checkNumber == 1) {
+			t.Errorf("%v Content-Length should get %v, got %v", name, tc.expected, w.Code)
+		}
+	}
+}
+
+func TestDecompressRequestGzip(t *testing.T) {
+	var body string
+	var readErr error
+	ts := httptest.NewServer(DecompressRequest()(bodyReader(&body, &readErr)))
+	defer ts.Close()
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte(`{"name":"gopher"}`))
+	zw.Close()
+	req, _ := http.NewRequest(http.MethodPost, ts.URL, &buf)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Encoding", "gzip")
+	resp, err := ts.Client().Do(req)
+	if err != nil || resp.StatusCode != http.StatusOK || readErr != nil || body != `{"name":"gopher"}` {
+		t.Errorf("Handler should read the decompressed body, got %q", body)
+	}
+}
+
+func TestDecompressRequestDeflate(t *testing.T) {
+	var body, encoding string
+	var readErr error
+	h := DecompressRequest()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding = r.Header.Get("Content-Encoding")
+		bodyReader(&body, &readErr).ServeHTTP(w, r)
+	}))
+
+	var zlibBuf, flateBuf bytes.Buffer
+	zw := zlib.NewWriter(&zlibBuf)
+	zw.Write([]byte("zlib body"))
+	zw.Close()
+	fw, _ := flate.NewWriter(&flateBuf, flate.DefaultCompression)
+	fw.Write([]byte("raw deflate body"))
+	fw.Close()
+
+	for expected, buf := range map[string]*bytes.Buffer{"zlib body": &zlibBuf, "raw deflate body": &flateBuf} {
+		req := httptest.NewRequest(http.MethodPost, "/", buf)
+		req.Header.Set("Content-Encoding", "deflate")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != http.StatusOK || readErr != nil || body != expected || encoding != "" {
+			t.Errorf("Handler should read the decompressed body %q, got %q", expected, body)
+		}
+		if req.Header.Get("Content-Encoding") != "deflate" {
+			t.Error("Headers of the caller's request should not be changed")
+		}
+	}
+}
+
+func TestDecompressRequestWithLimit(t *testing.T) {
+	var body string
+	var readErr error
+	h := DecompressRequestWithLimit(1024)(bodyReader(&body, &readErr))
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(make([]byte, 1<<20))
+	zw.Close()
+	req := httptest.NewRequest(http.MethodPost, "/", &buf)
+	req.Header.Set("Content-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusRequestEntityTooLarge || readErr == nil || len(body) > 1024 {
+		t.Errorf("Body decompressed past the limit should not be read, got %v after %v bytes", w.Code, len(body))
+	}
+}
+
+func TestDecompressRequestMalformed(t *testing.T) {
+	checkNumber = 0
+	h := DecompressRequest()(http.HandlerFunc(handlerTester))
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("not gzip"))
+	req.Header.Set("Content-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest || checkNumber != 0 {
+		t.Error("Malformed compressed body should be a bad request")
+	}
+}
+
+func TestRequireContentType(t *testing.T) {
+	checkNumber = 0
+	h := RequireContentType("application/json")(http.HandlerFunc(handlerTester))
+	for contentType, status := range map[string]int{
+		"application/json":                http.StatusOK,
+		"application/json; charset=utf-8": http.StatusOK,
+		"Application/JSON":                http.StatusOK,
+		"text/plain":                      http.StatusUnsupportedMediaType,
+		"":                                http.StatusUnsupportedMediaType,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("{}"))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != status {
+			t.Errorf("Content-Type %q should give status %v, got %v", contentType, status, w.Code)
+		}
+	}
+	if checkNumber != 3 {
+		t.Error("Allowed content types should reach the handler")
+	}
+
+	for _, method := range []string{http.MethodGet, http.MethodDelete} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(method, "/", nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("%v request without a body should not need a Content-Type", method)
+		}
+	}
+}
+
+type testPayload struct {
+	Name string `json:"name"`
+}
+
+func decodeJSONRequest(h http.Handler, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestDecodeJSON(t *testing.T) {
+	var decoded any
+	h := DecodeJSON(func() any { return &testPayload{} })(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		decoded, _ = DecodedBodyFromContext(r.Context())
+	}))
+
+	if w := decodeJSONRequest(h, `{"name":"gopher"}`); w.Code != http.StatusOK {
+		t.Fatalf("Valid body should be decoded, got %v", w.Code)
+	}
+	if p, ok := decoded.(*testPayload); !ok || p.Name != "gopher" {
+		t.Errorf("Decoded body should be on the context, got %#v", decoded)
+	}
+
+	for body, expected := range map[string]int{
+		`{"name":`:                       http.StatusBadRequest,
+		`{"name":"gopher","admin":true}`: http.StatusBadRequest,
+		`{"name":"a"} {"name":"b"}`:      http.StatusBadRequest,
+		`{"name":"gopher"}}`:             http.StatusBadRequest,
+		`{"name":"gopher"}]`:             http.StatusBadRequest,
+	} {
+		decoded = nil
+		if w := decodeJSONRequest(h, body); w.Code != expected || decoded != nil {
+			t.Errorf("Body %q should get %v, got %v", body, expected, w.Code)
+		}
+	}
+}
+
+func TestDecodeJSONWithOptions(t *testing.T) {
+	h := DecodeJSONWithOptions(func() any { return &testPayload{} }, JSONOptions{AllowUnknownFields: true, MaxBytes: 32})(http.HandlerFunc(handlerTester))
+	if w := decodeJSONRequest(h, `{"name":"gopher","admin":true}`); w.Code != http.StatusOK {
+		t.Errorf("Unknown fields should be allowed, got %v", w.Code)
+	}
+	if w := decodeJSONRequest(h, `{"name":"`+strings.Repeat("a", 64)+`"}`); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Body larger than the limit should get %v, got %v", http.StatusRequestEntityTooLarge, w.Code)
+	}
+}
+
+func TestDecodeJSONIgnoresOtherBodies(t *testing.T) {
+	checkNumber = 0
+	h := DecodeJSON(func() any { return &testPayload{} })(http.HandlerFunc(handlerTester))
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("name=gopher"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if checkNumber != 1 {
+		t.Error("Request without a JSON body should be passed to the handler")
+	}
+}
+
+func TestValidateJSONSchema(t *testing.T) {
+	schema := jsonschema.MustCompileString("user.json", `{
+		"type": "object",
+		"properties": {
+			"name": {"type": "string"},
+			"age": {"type": "integer", "minimum": 0}
+		},
+		"required": ["name"]
+	}`)
+	var body string
+	var readErr error
+	h := ValidateJSONSchema(schema)(bodyReader(&body, &readErr))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"name": "Ada", "age": 36}`)))
+	if w.Code != http.StatusOK || readErr != nil || body != `{"name": "Ada", "age": 36}` {
+		t.Errorf("Valid body should be passed to the handler, got %v %q", w.Code, body)
+	}
+
+	body = ""
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"age": -1}`)))
+	if w.Code != http.StatusUnprocessableEntity || body != "" {
+		t.Errorf("Invalid body should give a 422, got %v", w.Code)
+	}
+	for _, detail := range []string{"/: missing properties: 'name'", "/age: must be >= 0 but found -1"} {
+		if !strings.Contains(w.Body.String(), detail) {
+			t.Errorf("Response should list the error %q, got %q", detail, w.Body.String())
+		}
+	}
+
+	for _, malformed := range []string{`{"name": `, `{"name": "Ada"}}`} {
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(malformed)))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Malformed body %q should give a 400, got %v", malformed, w.Code)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package adaptd
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// BreakerOptions configure CircuitBreaker. Zero values are replaced by the defaults.
+type BreakerOptions struct {
+	// Window is how far back requests are counted. The default is 10 seconds.
+	Window time.Duration
+	// MinRequests is the number of requests in the window needed before the circuit can open. The default is 10.
+	MinRequests int
+	// FailureRatio is the fraction of failed requests in the window above which the circuit opens. The default is 0.5.
+	FailureRatio float64
+	// Cooldown is how long the circuit stays open before probe requests are allowed. The default is 5 seconds.
+	Cooldown time.Duration
+	// Probes is the number of successful probe requests needed to close the circuit. The default is 1.
+	Probes int
+}
+
+// CircuitBreaker adapter stops calling the handler once it is failing, so requests fail fast instead of piling up.
+// Responses with a 5xx status and handlers that panic are failures. When the fraction of failures in the window
+// is above the ratio, the circuit opens and requests are given a http.StatusServiceUnavailable error with a Retry-After header.
+// After the cooldown, requests are allowed through one at a time to probe the handler. The circuit closes
+// once enough probes succeed, and opens again if one fails.
+func CircuitBreaker(opts BreakerOptions) Adapter {
+	b := newBreaker(opts)
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			probe, allowed, retryAfter := b.allow()
+			if !allowed {
+				ServiceUnavailable(retryAfter).ServeHTTP(w, r)
+				return
+			}
+			sr := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			failed := true
+			defer func() { b.record(probe, failed) }()
+			h.ServeHTTP(sr, r)
+			failed = sr.status >= http.StatusInternalServerError
+		})
+	}
+}
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// breakerBuckets is the number of buckets the window is split into. Requests are counted in the bucket for
+// the time they finished, and buckets older than the window are not counted.
+const breakerBuckets = 10
+
+// breakerBucket counts the requests that finished in a slice of the window.
+type breakerBucket struct {
+	start    time.Time
+	requests int
+	failures int
+}
+
+type breaker struct {
+	opts      BreakerOptions
+	mu        sync.Mutex
+	state     breakerState
+	buckets   [breakerBuckets]breakerBucket
+	openedAt  time.Time
+	probing   bool
+	successes int
+}
+
+func newBreaker(opts BreakerOptions) *breaker {
+	if opts.Window <= 0 {
+		opts.Window = 10 * time.Second
+	}
+	if opts.MinRequests <= 0 {
+		opts.MinRequests = 10
+	}
+	if opts.FailureRatio <= 0 {
+		opts.FailureRatio = 0.5
+	}
+	if opts.Cooldown <= 0 {
+		opts.Cooldown = 5 * time.Second
+	}
+	if opts.Probes <= 0 {
+		opts.Probes = 1
+	}
+	return &breaker{opts: opts}
+}
+
+// allow reports whether a request can be handled and, if not, how long until it could be.
+// It also reports whether the request is the probe of a half-open circuit, which must be passed to record.
+func (b *breaker) allow() (probe, allowed bool, retryAfter time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == breakerOpen {
+		if wait := b.opts.Cooldown - time.Since(b.openedAt); wait > 0 {
+			return false, false, wait
+		}
+		b.state, b.successes = breakerHalfOpen, 0
+	}
+	if b.state == breakerHalfOpen {
+		if b.probing {
+			return false, false, b.opts.Cooldown
+		}
+		b.probing = true
+		return true, true, 0
+	}
+	return false, true, 0
+}
+
+// record records the outcome of a request that was allowed. Only the probe changes a half-open circuit,
+// so requests allowed before the circuit opened do not close or open it.
+func (b *breaker) record(probe, failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	if probe {
+		b.probing = false
+		if failed {
+			b.state, b.openedAt = breakerOpen, now
+			return
+		}
+		if b.successes++; b.successes >= b.opts.Probes {
+			b.state, b.buckets = breakerClosed, [breakerBuckets]breakerBucket{}
+		}
+		return
+	}
+	if b.state != breakerClosed {
+		return
+	}
+
+	requests, failures := b.count(now, failed)
+	if requests >= b.opts.MinRequests && float64(failures)/float64(requests) > b.opts.FailureRatio {
+		b.state, b.openedAt, b.buckets = breakerOpen, now, [breakerBuckets]breakerBucket{}
+	}
+}
+
+// count adds the outcome to the bucket for now and returns the number of requests and failures in the window.
+func (b *breaker) count(now time.Time, failed bool) (requests, failures int) {
+	width := b.opts.Window / breakerBuckets
+	if width <= 0 {
+		width = 1
+	}
+	start := now.Truncate(width)
+	bucket := &b.buckets[start.UnixNano()/int64(width)%breakerBuckets]
+	if !bucket.start.Equal(start) {
+		*bucket = breakerBucket{start: start}
+	}
+	bucket.requests++
+	if failed {
+		bucket.failures++
+	}
+	for _, bk := range b.buckets {
+		if now.Sub(bk.start) < b.opts.Window {
+			requests += bk.requests
+			failures += bk.failures
+		}
+	}
+	return requests, failures
+}
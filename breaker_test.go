@@ -0,0 +1,105 @@
+package adaptd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	failing := true
+	calls := 0
+	h := CircuitBreaker(BreakerOptions{MinRequests: 4, FailureRatio: 0.5, Cooldown: 20 * time.Millisecond, Probes: 2})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if failing {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	serve := func() int {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		return w.Code
+	}
+
+	for i := 0; i < 4; i++ {
+		if code := serve(); code != http.StatusInternalServerError {
+			t.Fatalf("Request before the circuit opens should reach the handler, got %v", code)
+		}
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusServiceUnavailable || calls != 4 || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("Open circuit should fail fast, got %v after %v calls", w.Code, calls)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	failing = false
+	if code := serve(); code != http.StatusOK {
+		t.Errorf("First probe should reach the handler, got %v", code)
+	}
+	if code := serve(); code != http.StatusOK {
+		t.Errorf("Second probe should reach the handler, got %v", code)
+	}
+	for i := 0; i < 5; i++ {
+		if code := serve(); code != http.StatusOK {
+			t.Fatalf("Closed circuit should call the handler, got %v", code)
+		}
+	}
+}
+
+func TestCircuitBreakerFailedProbe(t *testing.T) {
+	h := CircuitBreaker(BreakerOptions{MinRequests: 1, Cooldown: 20 * time.Millisecond})(http.HandlerFunc(handlerPanic))
+	serve := func() (code int) {
+		defer func() { recover() }()
+		w := httptest.NewRecorder()
+		defer func() { code = w.Code }()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		return w.Code
+	}
+
+	serve()
+	if code := serve(); code != http.StatusServiceUnavailable {
+		t.Fatalf("Panic should open the circuit, got %v", code)
+	}
+	time.Sleep(30 * time.Millisecond)
+	serve()
+	if code := serve(); code != http.StatusServiceUnavailable {
+		t.Errorf("Failed probe should open the circuit again, got %v", code)
+	}
+}
+
+func TestBreakerStaleRequestDuringProbe(t *testing.T) {
+	b := newBreaker(BreakerOptions{MinRequests: 1, Cooldown: 20 * time.Millisecond})
+	// A slow request is allowed while the circuit is closed, then another request opens it.
+	stale, _, _ := b.allow()
+	failing, _, _ := b.allow()
+	b.record(failing, true)
+	time.Sleep(30 * time.Millisecond)
+
+	if probe, allowed, _ := b.allow(); !probe || !allowed {
+		t.Fatal("First request after the cooldown should be the probe")
+	}
+	b.record(stale, false)
+	if _, allowed, _ := b.allow(); allowed {
+		t.Error("Request finishing from before the circuit opened should not end the probe")
+	}
+	b.record(true, false)
+	if probe, allowed, _ := b.allow(); probe || !allowed {
+		t.Error("Successful probe should close the circuit")
+	}
+}
+
+func TestBreakerWindow(t *testing.T) {
+	b := newBreaker(BreakerOptions{Window: 50 * time.Millisecond, MinRequests: 2})
+	b.record(false, true)
+	time.Sleep(60 * time.Millisecond)
+	b.record(false, true)
+	if _, allowed, _ := b.allow(); !allowed {
+		t.Error("Failures older than the window should not be counted")
+	}
+	b.record(false, true)
+	if _, allowed, _ := b.allow(); allowed {
+		t.Error("Failures in the window should open the circuit")
+	}
+}
@@ -0,0 +1,125 @@
+package adaptd
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"net/http"
+)
+
+// DefaultBufferLimit is the largest response, in bytes, that Buffer holds in memory.
+const DefaultBufferLimit = 1 << 20
+
+// Buffer adapter holds the status, headers, and body of the response until the handler returns,
+// so headers can be changed after the body is written. Responses larger than DefaultBufferLimit are streamed instead.
+func Buffer() Adapter {
+	return BufferWithLimit(DefaultBufferLimit)
+}
+
+// BufferWithLimit adapter holds the status, headers, and body of the response until the handler returns,
+// so headers can be changed after the body is written. Once the body is larger than limit bytes,
+// what is held is written and the rest of the response is streamed, so later header changes are not sent.
+// A limit of zero or less means the response is always held in memory.
+func BufferWithLimit(limit int64) Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			bw := newBufferingResponseWriter(w, limit)
+			h.ServeHTTP(bw, r)
+			bw.flush()
+		})
+	}
+}
+
+// bufferingResponseWriter holds the status and body of the response until flush is called
+// or the body is larger than the limit.
+type bufferingResponseWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+	limit       int64
+	streaming   bool
+}
+
+func newBufferingResponseWriter(w http.ResponseWriter, limit int64) *bufferingResponseWriter {
+	return &bufferingResponseWriter{ResponseWriter: w, status: http.StatusOK, limit: limit}
+}
+
+func (b *bufferingResponseWriter) WriteHeader(code int) {
+	if !b.wroteHeader {
+		b.status = code
+		b.wroteHeader = true
+	}
+}
+
+func (b *bufferingResponseWriter) Write(p []byte) (int, error) {
+	b.wroteHeader = true
+	if b.streaming {
+		return b.ResponseWriter.Write(p)
+	}
+	if b.limit > 0 && int64(b.body.Len()+len(p)) > b.limit {
+		b.flush()
+		return b.ResponseWriter.Write(p)
+	}
+	return b.body.Write(p)
+}
+
+// Flush does nothing while the response is held. Once it is streamed, the underlying http.ResponseWriter is flushed.
+func (b *bufferingResponseWriter) Flush() {
+	if f, ok := b.ResponseWriter.(http.Flusher); ok && b.streaming {
+		f.Flush()
+	}
+}
+
+// Hijack lets the handler take over the connection. Once it has, nothing more is written to the response.
+func (b *bufferingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := hijack(b.ResponseWriter)
+	if err == nil {
+		b.streaming = true
+	}
+	return conn, rw, err
+}
+
+// Unwrap returns the underlying http.ResponseWriter for http.ResponseController.
+func (b *bufferingResponseWriter) Unwrap() http.ResponseWriter {
+	return b.ResponseWriter
+}
+
+// flush writes the status and the body that is held, after which the response is streamed.
+func (b *bufferingResponseWriter) flush() {
+	if b.streaming {
+		return
+	}
+	b.streaming = true
+	b.ResponseWriter.WriteHeader(b.status)
+	b.ResponseWriter.Write(b.body.Bytes())
+	b.body.Reset()
+}
+
+// responseRecorder records a response without sending it, so it can be inspected or sent later.
+type responseRecorder struct {
+	header      http.Header
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func newResponseRecorder() *responseRecorder {
+	return &responseRecorder{header: make(http.Header), status: http.StatusOK}
+}
+
+func (rr *responseRecorder) Header() http.Header {
+	return rr.header
+}
+
+func (rr *responseRecorder) WriteHeader(code int) {
+	if !rr.wroteHeader {
+		rr.status = code
+		rr.wroteHeader = true
+	}
+}
+
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	rr.wroteHeader = true
+	return rr.body.Write(b)
+}
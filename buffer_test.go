@@ -0,0 +1,44 @@
+package adaptd
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBufferAllowsHeaderChangesAfterWrite(t *testing.T) {
+	h := Buffer()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, "hello, world")
+		w.Header().Set("X-Length", "12")
+	}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusCreated || w.Body.String() != "hello, world" {
+		t.Errorf("Buffered response should keep its status and body, got %v %q", w.Code, w.Body.String())
+	}
+	if w.Header().Get("X-Length") != "12" {
+		t.Error("Header set after the body was written should be sent")
+	}
+}
+
+func TestBufferStreamsOversizedResponses(t *testing.T) {
+	body := strings.Repeat("a", 100)
+	h := BufferWithLimit(64)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, body[:50])
+		io.WriteString(w, body[50:])
+		w.Header().Set("X-Late", "true")
+	}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Body.String() != body {
+		t.Errorf("Oversized response should stream the whole body, got %v bytes", w.Body.Len())
+	}
+	if w.Result().Header.Get("X-Late") != "" {
+		t.Error("Header set after an oversized response started streaming should not be sent")
+	}
+}
@@ -0,0 +1,343 @@
+package adaptd
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ETag adapter adds an ETag to successful responses to GET and HEAD requests. If the handler sets an ETag header,
+// e.g. a weak W/"v2" for responses that are equivalent but not byte-for-byte equal, it is used.
+// Otherwise a strong ETag, the SHA-256 of the body, is added.
+// If the request's If-None-Match header matches the ETag, a http.StatusNotModified response without a body is given.
+// Responses to HEAD requests without a body only get the ETag the handler sets, as the body GET would give is not known.
+// The response is buffered in order to compute the ETag. Responses larger than DefaultBufferLimit are streamed without an ETag.
+//
+// For requests with other methods and an If-Match header, like a PUT, the handler sets the CurrentETagHeader
+// to the ETag of the resource before it was changed. When the response is written, the header is removed and, if
+// it does not match, the handler's response is replaced by a http.StatusPreconditionFailed error.
+// The strong comparison is used, so weak ETags never match. As the check happens after the handler has run,
+// handlers that do not want to change the resource on a mismatch should make the change in a transaction
+// rolled back on the error status, or check with IfMatch first.
+func ETag() Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				if r.Header.Get("If-Match") == "" {
+					h.ServeHTTP(w, r)
+					return
+				}
+				iw := &ifMatchWriter{ResponseWriter: w, ifMatch: r.Header.Get("If-Match")}
+				h.ServeHTTP(iw, r.WithContext(context.WithValue(r.Context(), ifMatchKey, iw)))
+				if (iw.failed || iw.Header().Get(CurrentETagHeader) != "") && !iw.wroteHeader {
+					iw.WriteHeader(http.StatusOK)
+				}
+				return
+			}
+			bw := newBufferingResponseWriter(w, DefaultBufferLimit)
+			h.ServeHTTP(bw, r)
+			if bw.status != http.StatusOK || bw.streaming {
+				bw.flush()
+				return
+			}
+			if r.Method == http.MethodHead && w.Header().Get("ETag") == "" && bw.body.Len() == 0 {
+				// The ETag of the empty body would not match the one for the GET request.
+				bw.flush()
+				return
+			}
+			etag := responseETag(w.Header(), bw.body.Bytes())
+			w.Header().Set("ETag", etag)
+			if etagMatches(r.Header.Get("If-None-Match"), etag) {
+				w.Header().Del("Content-Length")
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			bw.flush()
+		})
+	}
+}
+
+// responseETag returns the ETag set by the handler, or the strong ETag of the body if there is none.
+func responseETag(header http.Header, body []byte) string {
+	if etag := header.Get("ETag"); etag != "" {
+		return etag
+	}
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+// CurrentETagHeader is the response header in which handlers behind ETag give the current ETag of the resource
+// for requests with an If-Match header. It is not sent to the client.
+const CurrentETagHeader = "Current-ETag"
+
+// IfMatch reports whether the request's If-Match header, if any, matches the current ETag of the resource.
+// Handlers can call it before changing the resource and stop if it returns false. The strong comparison is used,
+// so weak ETags never match. Behind ETag, the handler's response is then replaced by a http.StatusPreconditionFailed error.
+func IfMatch(r *http.Request, etag string) bool {
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" || strongETagMatches(ifMatch, etag) {
+		return true
+	}
+	if iw, ok := r.Context().Value(ifMatchKey).(*ifMatchWriter); ok {
+		iw.failed = true
+	}
+	return false
+}
+
+// ifMatchWriter gives a http.StatusPreconditionFailed error instead of the response if the current ETag
+// set by the handler does not match the If-Match header, or IfMatch has failed.
+type ifMatchWriter struct {
+	http.ResponseWriter
+	ifMatch     string
+	failed      bool
+	wroteHeader bool
+}
+
+func (iw *ifMatchWriter) WriteHeader(code int) {
+	if iw.wroteHeader {
+		return
+	}
+	iw.wroteHeader = true
+	if current := iw.Header().Get(CurrentETagHeader); current != "" {
+		iw.Header().Del(CurrentETagHeader)
+		if !strongETagMatches(iw.ifMatch, current) {
+			iw.failed = true
+		}
+	}
+	if iw.failed {
+		http.Error(iw.ResponseWriter, http.StatusText(http.StatusPreconditionFailed), http.StatusPreconditionFailed)
+		return
+	}
+	iw.ResponseWriter.WriteHeader(code)
+}
+
+func (iw *ifMatchWriter) Write(b []byte) (int, error) {
+	if !iw.wroteHeader {
+		iw.WriteHeader(http.StatusOK)
+	}
+	if iw.failed {
+		return len(b), nil
+	}
+	return iw.ResponseWriter.Write(b)
+}
+
+func (iw *ifMatchWriter) Flush() {
+	if f, ok := iw.ResponseWriter.(http.Flusher); ok {
+		if !iw.wroteHeader {
+			iw.WriteHeader(http.StatusOK)
+		}
+		f.Flush()
+	}
+}
+
+// Unwrap returns the underlying http.ResponseWriter for http.ResponseController.
+func (iw *ifMatchWriter) Unwrap() http.ResponseWriter {
+	return iw.ResponseWriter
+}
+
+// CacheControl adapter adds a Cache-Control header with the given directives followed by the max-age,
+// e.g. CacheControl(time.Hour, "public", "immutable") gives "public, immutable, max-age=3600".
+// An Expires header with the time the response expires is also added.
+func CacheControl(maxAge time.Duration, directives ...string) Adapter {
+	cacheControl := strings.Join(append(append([]string{}, directives...), "max-age="+strconv.FormatInt(int64(maxAge/time.Second), 10)), ", ")
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", cacheControl)
+			w.Header().Set("Expires", time.Now().Add(maxAge).UTC().Format(http.TimeFormat))
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// NoCache adapter adds headers telling clients and proxies not to cache the response.
+// This is useful for pages like login pages.
+func NoCache() Adapter {
+	return AddHeaders(map[string]string{
+		"Cache-Control": "no-store, no-cache, must-revalidate",
+		"Pragma":        "no-cache",
+	})
+}
+
+// CachedResponse is a response stored by ResponseCache.
+type CachedResponse struct {
+	Status  int
+	Header  http.Header
+	Body    []byte
+	Stored  time.Time
+	Expires time.Time
+}
+
+// CacheStore stores the responses for ResponseCache. Implementations must be safe for concurrent use.
+type CacheStore interface {
+	// Get returns the response stored for the key, if any.
+	Get(key string) (*CachedResponse, bool)
+	// Set stores the response for the key.
+	Set(key string, resp *CachedResponse)
+}
+
+// ResponseCache adapter caches successful responses to GET and HEAD requests in the store for the ttl.
+// Responses are cached by method, host, path, query, and the values of the request headers named in the Vary header.
+// Cached responses are served without calling the handler, with an Age header giving the seconds since they were stored.
+// Responses with a Cache-Control of no-store or private, or a Vary of *, are not cached.
+// Responses to requests with an Authorization header, and responses that set cookies, are only cached
+// if their Cache-Control has public or s-maxage. Set-Cookie headers are never stored.
+// The response is buffered in order to store it. Responses larger than DefaultBufferLimit are streamed and not cached.
+func ResponseCache(store CacheStore, ttl time.Duration) Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				h.ServeHTTP(w, r)
+				return
+			}
+			now := time.Now()
+			key := r.Method + " " + r.Host + r.URL.RequestURI()
+			if resp, ok := store.Get(key); ok && now.Before(resp.Expires) {
+				if resp, ok := store.Get(varyKey(key, resp.Header, r)); ok && now.Before(resp.Expires) {
+					for name, values := range resp.Header {
+						w.Header()[name] = append([]string(nil), values...)
+					}
+					w.Header().Set("Age", strconv.FormatInt(int64(now.Sub(resp.Stored)/time.Second), 10))
+					w.WriteHeader(resp.Status)
+					w.Write(resp.Body)
+					return
+				}
+			}
+
+			bw := newBufferingResponseWriter(w, DefaultBufferLimit)
+			h.ServeHTTP(bw, r)
+			if bw.status == http.StatusOK && !bw.streaming && isCacheable(r, w.Header()) {
+				header := w.Header().Clone()
+				header.Del("Set-Cookie")
+				resp := &CachedResponse{
+					Status:  bw.status,
+					Header:  header,
+					Body:    append([]byte(nil), bw.body.Bytes()...),
+					Stored:  now,
+					Expires: now.Add(ttl),
+				}
+				store.Set(key, resp)
+				store.Set(varyKey(key, resp.Header, r), resp)
+			}
+			bw.flush()
+		})
+	}
+}
+
+// varyKey adds the values of the request headers named in the Vary header to the key.
+func varyKey(key string, header http.Header, r *http.Request) string {
+	for _, value := range header.Values("Vary") {
+		for _, field := range strings.Split(value, ",") {
+			if field = strings.TrimSpace(field); field != "" {
+				key += "\n" + http.CanonicalHeaderKey(field) + ": " + strings.Join(r.Header.Values(field), ", ")
+			}
+		}
+	}
+	return key
+}
+
+// isCacheable reports whether the request and response headers allow the response to be stored by a shared cache.
+// Responses to requests with credentials, or that set cookies, are likely meant for one client,
+// so they are only stored if the Cache-Control says they are public.
+func isCacheable(r *http.Request, header http.Header) bool {
+	public := false
+	for _, value := range header.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			name, _, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			switch strings.ToLower(strings.TrimSpace(name)) {
+			case "no-store", "private":
+				return false
+			case "public", "s-maxage":
+				public = true
+			}
+		}
+	}
+	if !public && (r.Header.Get("Authorization") != "" || header.Get("Set-Cookie") != "") {
+		return false
+	}
+	for _, value := range header.Values("Vary") {
+		if strings.TrimSpace(value) == "*" {
+			return false
+		}
+	}
+	return true
+}
+
+// MemoryCacheStore is a CacheStore that keeps responses in memory.
+// Once it is full, the least recently used response is removed to make room.
+type MemoryCacheStore struct {
+	mu         sync.Mutex
+	maxEntries int
+	order      *list.List
+	entries    map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key  string
+	resp *CachedResponse
+}
+
+// NewMemoryCacheStore creates an empty MemoryCacheStore holding up to maxEntries responses.
+// A maxEntries of zero or less means the number of responses is not limited.
+func NewMemoryCacheStore(maxEntries int) *MemoryCacheStore {
+	return &MemoryCacheStore{maxEntries: maxEntries, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+// Get implements CacheStore.
+func (m *MemoryCacheStore) Get(key string) (*CachedResponse, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.entries[key]
+	if !ok {
+		return nil, false
+	}
+	m.order.MoveToFront(e)
+	return e.Value.(*cacheEntry).resp, true
+}
+
+// Set implements CacheStore.
+func (m *MemoryCacheStore) Set(key string, resp *CachedResponse) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e, ok := m.entries[key]; ok {
+		e.Value.(*cacheEntry).resp = resp
+		m.order.MoveToFront(e)
+		return
+	}
+	m.entries[key] = m.order.PushFront(&cacheEntry{key: key, resp: resp})
+	for m.maxEntries > 0 && m.order.Len() > m.maxEntries {
+		oldest := m.order.Back()
+		m.order.Remove(oldest)
+		delete(m.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// etagMatches reports whether the ETag is in the list of ETags from an If-None-Match header.
+// The weak comparison is used, so W/"abc" matches "abc".
+func etagMatches(header, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// strongETagMatches reports whether the ETag is in the list of ETags from an If-Match header.
+// The strong comparison is used, so weak ETags never match.
+func strongETagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || (candidate == etag && !strings.HasPrefix(etag, "W/")) {
+			return true
+		}
+	}
+	return false
+}
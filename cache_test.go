@@ -0,0 +1,397 @@
+package adaptd
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func helloHandler(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("hello, world"))
+}
+
+func TestETag(t *testing.T) {
+	ts := httptest.NewServer(ETag()(http.HandlerFunc(helloHandler)))
+	defer ts.Close()
+	client := ts.Client()
+
+	resp, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	etag := resp.Header.Get("ETag")
+	if resp.StatusCode != http.StatusOK || string(body) != "hello, world" || etag == "" {
+		t.Fatal("First request should give the body with an ETag")
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+	req.Header.Set("If-None-Match", etag)
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotModified || len(body) != 0 || resp.Header.Get("ETag") != etag {
+		t.Error("Request with a matching ETag should not be modified")
+	}
+
+	req, _ = http.NewRequest(http.MethodGet, ts.URL, nil)
+	req.Header.Set("If-None-Match", `"other"`)
+	resp, err = client.Do(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Error("Request with a different ETag should give the body")
+	}
+}
+
+func TestETagOnlySuccessfulGets(t *testing.T) {
+	h := ETag()(http.HandlerFunc(http.NotFound))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusNotFound || w.Header().Get("ETag") != "" || w.Body.Len() == 0 {
+		t.Error("Unsuccessful responses should not have an ETag")
+	}
+
+	h = ETag()(http.HandlerFunc(helloHandler))
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", nil))
+	if w.Header().Get("ETag") != "" {
+		t.Error("POST responses should not have an ETag")
+	}
+}
+
+func TestETagHead(t *testing.T) {
+	h := ETag()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			helloHandler(w, r)
+		}
+	}))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodHead, "/", nil))
+	if w.Code != http.StatusOK || w.Header().Get("ETag") != "" {
+		t.Errorf("HEAD response without a body should not have an ETag, got %q", w.Header().Get("ETag"))
+	}
+
+	get := httptest.NewRecorder()
+	ETag()(http.HandlerFunc(helloHandler)).ServeHTTP(get, httptest.NewRequest(http.MethodGet, "/", nil))
+	head := httptest.NewRecorder()
+	ETag()(http.HandlerFunc(helloHandler)).ServeHTTP(head, httptest.NewRequest(http.MethodHead, "/", nil))
+	if head.Header().Get("ETag") != get.Header().Get("ETag") {
+		t.Errorf("HEAD response with the body should have the ETag of the GET response, got %q", head.Header().Get("ETag"))
+	}
+}
+
+func TestETagLargeResponse(t *testing.T) {
+	large := strings.Repeat("a", DefaultBufferLimit+1)
+	h := ETag()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(large))
+	}))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Body.Len() != len(large) || w.Header().Get("ETag") != "" {
+		t.Errorf("Large response should be passed through without an ETag, got %v bytes", w.Body.Len())
+	}
+}
+
+func TestETagFromHandler(t *testing.T) {
+	h := ETag()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `W/"v1"`)
+		helloHandler(w, r)
+	}))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Header().Get("ETag") != `W/"v1"` || w.Body.String() != "hello, world" {
+		t.Errorf("ETag set by the handler should be kept, got %v", w.Header().Get("ETag"))
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("If-None-Match", `W/"v1"`)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("Request matching a weak ETag should not be modified, got %v", w.Code)
+	}
+}
+
+func TestETagIfMatch(t *testing.T) {
+	version := 1
+	calls := 0
+	h := ETag()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set(CurrentETagHeader, `"v`+strconv.Itoa(version)+`"`)
+		if !strongETagMatches(r.Header.Get("If-Match"), `"v`+strconv.Itoa(version)+`"`) {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		version++
+		w.Header().Set("ETag", `"v`+strconv.Itoa(version)+`"`)
+		helloHandler(w, r)
+	}))
+
+	// The cases are in order, as each successful PUT changes the ETag.
+	for _, c := range []struct {
+		ifMatch  string
+		expected int
+	}{
+		{`"v1"`, http.StatusOK},
+		{`"v1"`, http.StatusPreconditionFailed},
+		{`"v0", "v2"`, http.StatusOK},
+		{`W/"v3"`, http.StatusPreconditionFailed},
+		{`*`, http.StatusOK},
+	} {
+		req := httptest.NewRequest(http.MethodPut, "/", nil)
+		req.Header.Set("If-Match", c.ifMatch)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != c.expected || w.Header().Get(CurrentETagHeader) != "" {
+			t.Errorf("PUT with If-Match %v should give %v, got %v", c.ifMatch, c.expected, w.Code)
+		}
+	}
+	if version != 4 || calls != 5 {
+		t.Errorf("Only PUT requests with a matching ETag should change the resource, got version %v after %v calls", version, calls)
+	}
+}
+
+func TestETagIfMatchWithoutBody(t *testing.T) {
+	h := ETag()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(CurrentETagHeader, `"v2"`)
+	}))
+	req := httptest.NewRequest(http.MethodDelete, "/", nil)
+	req.Header.Set("If-Match", `"v1"`)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusPreconditionFailed {
+		t.Errorf("Stale If-Match should give a 412 when the handler writes nothing, got %v", w.Code)
+	}
+}
+
+func TestETagIfMatchAfterWrite(t *testing.T) {
+	h := ETag()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Checked", "true")
+		if !IfMatch(r, `"v2"`) {
+			w.Write([]byte("not changed"))
+			return
+		}
+		helloHandler(w, r)
+	}))
+	req := httptest.NewRequest(http.MethodDelete, "/", nil)
+	req.Header.Set("If-Match", `"v1"`)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusPreconditionFailed || strings.Contains(w.Body.String(), "not changed") {
+		t.Errorf("Response after a failed IfMatch should be replaced, got %v %q", w.Code, w.Body)
+	}
+}
+
+func TestIfMatchWithoutETag(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPut, "/", nil)
+	if !IfMatch(req, `"v1"`) {
+		t.Error("Request without an If-Match header should match")
+	}
+	req.Header.Set("If-Match", `"v0"`)
+	if IfMatch(req, `"v1"`) {
+		t.Error("Request with a different If-Match header should not match")
+	}
+}
+
+func TestCacheControl(t *testing.T) {
+	h := CacheControl(time.Hour, "public", "immutable")(http.HandlerFunc(helloHandler))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if cc := w.Header().Get("Cache-Control"); cc != "public, immutable, max-age=3600" {
+		t.Errorf("Unexpected Cache-Control header %q", cc)
+	}
+	expires, err := time.Parse(http.TimeFormat, w.Header().Get("Expires"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Until(expires); d < 59*time.Minute || d > time.Hour {
+		t.Errorf("Expires should be an hour from now, got %v", w.Header().Get("Expires"))
+	}
+}
+
+func TestNoCache(t *testing.T) {
+	h := NoCache()(http.HandlerFunc(helloHandler))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if cc := w.Header().Get("Cache-Control"); cc != "no-store, no-cache, must-revalidate" {
+		t.Errorf("Unexpected Cache-Control header %q", cc)
+	}
+	if pragma := w.Header().Get("Pragma"); pragma != "no-cache" {
+		t.Errorf("Unexpected Pragma header %q", pragma)
+	}
+}
+
+func TestResponseCache(t *testing.T) {
+	calls := 0
+	h := ResponseCache(NewMemoryCacheStore(10), time.Minute)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("X-Calls", strconv.Itoa(calls))
+		w.Write([]byte("expensive"))
+	}))
+
+	miss := httptest.NewRecorder()
+	h.ServeHTTP(miss, httptest.NewRequest(http.MethodGet, "/report", nil))
+	if calls != 1 || miss.Body.String() != "expensive" || miss.Header().Get("Age") != "" {
+		t.Fatal("First request should miss the cache")
+	}
+
+	hit := httptest.NewRecorder()
+	h.ServeHTTP(hit, httptest.NewRequest(http.MethodGet, "/report", nil))
+	if calls != 1 {
+		t.Error("Second request should be served from the cache")
+	}
+	if hit.Body.String() != "expensive" || hit.Header().Get("X-Calls") != "1" || hit.Header().Get("Age") != "0" {
+		t.Errorf("Cached response should be replayed with an Age, got %q %v", hit.Body.String(), hit.Header())
+	}
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/report?year=2020", nil))
+	if calls != 2 {
+		t.Error("Request with a different query should miss the cache")
+	}
+}
+
+func TestResponseCacheNoStore(t *testing.T) {
+	calls := 0
+	h := ResponseCache(NewMemoryCacheStore(10), time.Minute)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Cache-Control", "no-store")
+		helloHandler(w, r)
+	}))
+	for i := 0; i < 2; i++ {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}
+	if calls != 2 {
+		t.Error("no-store responses should not be cached")
+	}
+}
+
+func TestResponseCacheAuthorization(t *testing.T) {
+	for cacheControl, expected := range map[string]int{"": 2, "public, max-age=60": 1, "s-maxage=60": 1} {
+		calls := 0
+		h := ResponseCache(NewMemoryCacheStore(10), time.Minute)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			if cacheControl != "" {
+				w.Header().Set("Cache-Control", cacheControl)
+			}
+			helloHandler(w, r)
+		}))
+		for i := 0; i < 2; i++ {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Authorization", "Bearer secret")
+			h.ServeHTTP(httptest.NewRecorder(), req)
+		}
+		if calls != expected {
+			t.Errorf("Handler of authorized requests with Cache-Control %q should be called %v times, got %v", cacheControl, expected, calls)
+		}
+	}
+}
+
+func TestResponseCacheSetCookie(t *testing.T) {
+	for cacheControl, expected := range map[string]int{"": 2, "public": 1} {
+		calls := 0
+		h := ResponseCache(NewMemoryCacheStore(10), time.Minute)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			if cacheControl != "" {
+				w.Header().Set("Cache-Control", cacheControl)
+			}
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "secret"})
+			helloHandler(w, r)
+		}))
+		var w *httptest.ResponseRecorder
+		for i := 0; i < 2; i++ {
+			w = httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		}
+		if calls != expected {
+			t.Errorf("Handler of responses setting cookies with Cache-Control %q should be called %v times, got %v", cacheControl, expected, calls)
+		}
+		if calls == 1 && w.Header().Get("Set-Cookie") != "" {
+			t.Errorf("Cached response should not set cookies, got %q", w.Header().Get("Set-Cookie"))
+		}
+	}
+}
+
+func TestResponseCacheVary(t *testing.T) {
+	calls := 0
+	h := ResponseCache(NewMemoryCacheStore(10), time.Minute)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Vary", "Accept-Language")
+		w.Write([]byte(r.Header.Get("Accept-Language")))
+	}))
+	for _, lang := range []string{"en", "fr", "en", "fr"} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept-Language", lang)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Body.String() != lang {
+			t.Errorf("Expected the %v response, got %q", lang, w.Body.String())
+		}
+	}
+	if calls != 2 {
+		t.Errorf("Each Accept-Language should be cached separately, got %v calls", calls)
+	}
+}
+
+func TestResponseCacheHost(t *testing.T) {
+	h := ResponseCache(NewMemoryCacheStore(10), time.Minute)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Host))
+	}))
+	for _, host := range []string{"a.example.com", "b.example.com"} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://"+host+"/", nil))
+		if w.Body.String() != host {
+			t.Errorf("Expected the response for %v, got %q", host, w.Body.String())
+		}
+	}
+}
+
+func TestResponseCacheLargeResponse(t *testing.T) {
+	calls := 0
+	large := strings.Repeat("a", DefaultBufferLimit+1)
+	h := ResponseCache(NewMemoryCacheStore(10), time.Minute)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Write([]byte(large))
+	}))
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		if w.Body.Len() != len(large) {
+			t.Errorf("Large response should be given in full, got %v bytes", w.Body.Len())
+		}
+	}
+	if calls != 2 {
+		t.Error("Responses larger than the buffer limit should not be cached")
+	}
+}
+
+func TestMemoryCacheStoreUnlimited(t *testing.T) {
+	store := NewMemoryCacheStore(0)
+	store.Set("a", &CachedResponse{})
+	store.Set("b", &CachedResponse{})
+	if _, ok := store.Get("a"); !ok {
+		t.Error("Store without a limit should keep every response")
+	}
+}
+
+func TestMemoryCacheStoreEvictsLeastRecentlyUsed(t *testing.T) {
+	store := NewMemoryCacheStore(2)
+	store.Set("a", &CachedResponse{})
+	store.Set("b", &CachedResponse{})
+	store.Get("a")
+	store.Set("c", &CachedResponse{})
+	if _, ok := store.Get("b"); ok {
+		t.Error("Least recently used response should be evicted")
+	}
+	if _, ok := store.Get("a"); !ok {
+		t.Error("Recently used response should be kept")
+	}
+}
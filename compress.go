@@ -0,0 +1,141 @@
+package adaptd
+
+import (
+	"bufio"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// Brotli adapter compresses responses with the given brotli quality, from brotli.BestSpeed to brotli.BestCompression,
+// for clients that accept the br encoding. Responses that are already encoded, have no body,
+// or have a content type that is already compressed, such as images, are not compressed.
+// Other clients get the response unchanged.
+func Brotli(quality int) Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			addVary(w.Header(), "Accept-Encoding")
+			if r.Method == http.MethodHead || !acceptsEncoding(r.Header.Get("Accept-Encoding"), "br") {
+				h.ServeHTTP(w, r)
+				return
+			}
+			bw := &brotliWriter{ResponseWriter: w, quality: quality}
+			defer bw.Close()
+			h.ServeHTTP(bw, r)
+		})
+	}
+}
+
+// brotliWriter decides whether to compress the response when the header is written.
+type brotliWriter struct {
+	http.ResponseWriter
+	quality     int
+	writer      *brotli.Writer
+	wroteHeader bool
+}
+
+func (b *brotliWriter) WriteHeader(status int) {
+	if b.wroteHeader {
+		return
+	}
+	b.wroteHeader = true
+	header := b.Header()
+	if status >= http.StatusOK && status != http.StatusNoContent && status != http.StatusNotModified &&
+		header.Get("Content-Encoding") == "" && !isCompressedType(header.Get("Content-Type")) {
+		header.Set("Content-Encoding", "br")
+		header.Del("Content-Length")
+		b.writer = brotli.NewWriterLevel(b.ResponseWriter, b.quality)
+	}
+	b.ResponseWriter.WriteHeader(status)
+}
+
+func (b *brotliWriter) Write(p []byte) (int, error) {
+	if !b.wroteHeader {
+		if b.Header().Get("Content-Type") == "" {
+			b.Header().Set("Content-Type", http.DetectContentType(p))
+		}
+		b.WriteHeader(http.StatusOK)
+	}
+	if b.writer == nil {
+		return b.ResponseWriter.Write(p)
+	}
+	return b.writer.Write(p)
+}
+
+// Flush flushes the compressed data written so far to the client.
+func (b *brotliWriter) Flush() {
+	if b.writer != nil {
+		b.writer.Flush()
+	}
+	if f, ok := b.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack allows the handler to take over the connection if the underlying http.ResponseWriter supports it.
+func (b *brotliWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hj, ok := b.ResponseWriter.(http.Hijacker); ok {
+		return hj.Hijack()
+	}
+	return nil, nil, http.ErrNotSupported
+}
+
+// Unwrap returns the underlying http.ResponseWriter for http.ResponseController.
+func (b *brotliWriter) Unwrap() http.ResponseWriter {
+	return b.ResponseWriter
+}
+
+// Close finishes the compressed stream, if any.
+func (b *brotliWriter) Close() error {
+	if b.writer == nil {
+		return nil
+	}
+	return b.writer.Close()
+}
+
+// acceptsEncoding reports whether the Accept-Encoding header allows the encoding with a non-zero q-value.
+func acceptsEncoding(acceptEncoding, encoding string) bool {
+	accepted := false
+	for _, value := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(value), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != encoding && name != "*" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+		if name == encoding {
+			return q > 0
+		}
+		accepted = q > 0
+	}
+	return accepted
+}
+
+// isCompressedType reports whether responses with the content type are already compressed.
+func isCompressedType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	switch {
+	case mediaType == "image/svg+xml":
+		return false
+	case strings.HasPrefix(mediaType, "image/"), strings.HasPrefix(mediaType, "video/"), strings.HasPrefix(mediaType, "audio/"):
+		return true
+	}
+	switch mediaType {
+	case "application/zip", "application/gzip", "application/x-gzip", "application/x-brotli",
+		"application/x-7z-compressed", "application/x-rar-compressed", "application/zstd", "font/woff", "font/woff2":
+		return true
+	}
+	return false
+}
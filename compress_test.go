@@ -0,0 +1,65 @@
+package adaptd
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+func TestBrotli(t *testing.T) {
+	body := strings.Repeat("Hello, world! ", 100)
+	h := Brotli(brotli.DefaultCompression)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "1400")
+		io.WriteString(w, body)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip, br")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	if w.Header().Get("Content-Encoding") != "br" || w.Header().Get("Vary") != "Accept-Encoding" {
+		t.Errorf("Response should be brotli encoded, got headers %v", w.Header())
+	}
+	if w.Header().Get("Content-Length") != "" {
+		t.Error("Content-Length of the uncompressed body should be removed")
+	}
+	decoded, err := io.ReadAll(brotli.NewReader(w.Body))
+	if err != nil || string(decoded) != body {
+		t.Errorf("Decoded body does not match: %v", err)
+	}
+}
+
+func TestBrotliNotAccepted(t *testing.T) {
+	h := Brotli(brotli.DefaultCompression)(http.HandlerFunc(helloHandler))
+	for _, acceptEncoding := range []string{"", "gzip", "br;q=0, gzip", "*;q=0"} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Header().Get("Content-Encoding") != "" || w.Body.String() != "hello, world" {
+			t.Errorf("Accept-Encoding %q should get a plain response", acceptEncoding)
+		}
+		if w.Header().Get("Vary") != "Accept-Encoding" {
+			t.Error("Vary header should include Accept-Encoding")
+		}
+	}
+}
+
+func TestBrotliSkipsCompressedTypes(t *testing.T) {
+	h := Brotli(brotli.DefaultCompression)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("not really a png"))
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", "br")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Header().Get("Content-Encoding") != "" || w.Body.String() != "not really a png" {
+		t.Error("Already compressed content types should not be compressed")
+	}
+}
@@ -0,0 +1,56 @@
+package adaptd
+
+import (
+	"context"
+	"net/http"
+)
+
+// contextKey is used for values the adapters store on a request's context
+// so that they do not collide with keys from other packages.
+type contextKey int
+
+const (
+	apiVersionKey contextKey = iota
+	txKey
+	realIPKey
+	peerIPKey
+	languageKey
+	decodedBodyKey
+	serverTimingKey
+	statusKey
+	cspNonceKey
+	ifMatchKey
+)
+
+// ContextKey is a type-safe key for values of type T on a context.
+// Each key created by NewContextKey is distinct, even for the same type.
+type ContextKey[T any] struct {
+	// The field makes the struct non-zero size, so each new key has a distinct address.
+	_ byte
+}
+
+// NewContextKey creates a new key for values of type T.
+func NewContextKey[T any]() *ContextKey[T] {
+	return &ContextKey[T]{}
+}
+
+// Set returns a copy of the context with the value stored for the key.
+func (k *ContextKey[T]) Set(ctx context.Context, v T) context.Context {
+	return context.WithValue(ctx, k, v)
+}
+
+// Get returns the value stored on the context for the key.
+func (k *ContextKey[T]) Get(ctx context.Context) (T, bool) {
+	v, ok := ctx.Value(k).(T)
+	return v, ok
+}
+
+// WithContextValue adapter stores the value for the key on the request's context before calling the handler.
+// The key should be a *ContextKey or have an unexported type so it does not collide with keys from other packages.
+func WithContextValue(key, val any) Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), key, val)))
+		})
+	}
+}
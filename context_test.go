@@ -0,0 +1,41 @@
+package adaptd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWithContextValue(t *testing.T) {
+	tenant := NewContextKey[string]()
+	region := NewContextKey[string]()
+	limit := NewContextKey[int]()
+
+	var gotTenant, gotRegion string
+	var gotLimit int
+	var regionOK bool
+	h := Adapt(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotTenant, _ = tenant.Get(r.Context())
+		gotRegion, regionOK = region.Get(r.Context())
+		gotLimit, _ = limit.Get(r.Context())
+	}), WithContextValue(tenant, "acme"), WithContextValue(limit, 10))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if gotTenant != "acme" || gotLimit != 10 {
+		t.Errorf("Handler should read the seeded values, got %q and %v", gotTenant, gotLimit)
+	}
+	if regionOK || gotRegion != "" {
+		t.Error("Different key of the same type should not collide")
+	}
+}
+
+func TestContextKeySet(t *testing.T) {
+	first, second := NewContextKey[string](), NewContextKey[string]()
+	ctx := first.Set(httptest.NewRequest(http.MethodGet, "/", nil).Context(), "first")
+	if v, ok := first.Get(ctx); !ok || v != "first" {
+		t.Errorf("Value should be stored for the key, got %q", v)
+	}
+	if _, ok := second.Get(ctx); ok {
+		t.Error("Keys of the same type should be distinct")
+	}
+}
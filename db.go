@@ -0,0 +1,94 @@
+package adaptd
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+)
+
+// PutTxOnContext adapter begins a database transaction and puts it on the request's context
+// where it can be retrieved by handlers with TxFromContext.
+// The transaction is committed after the handler returns and rolled back if the handler panics
+// or responds with a status of http.StatusInternalServerError or greater.
+// The response is buffered, up to DefaultBufferLimit, until the transaction is committed, so a failed commit
+// gives a http.StatusInternalServerError error instead. Larger responses are streamed and a failed commit is only logged.
+// If the transaction cannot be started, a http.StatusInternalServerError error is given.
+func PutTxOnContext(db *sql.DB) Adapter {
+	return PutTxOnContextWithOptions(db, TxAdapterOptions{})
+}
+
+// PutTxOnContextOpts adapter is like PutTxOnContext except that the transaction is started with the given options,
+// e.g. to set the isolation level or make the transaction read-only.
+// The transaction is tied to the request's context, so it is rolled back if the request is cancelled.
+func PutTxOnContextOpts(db *sql.DB, opts *sql.TxOptions) Adapter {
+	return PutTxOnContextWithOptions(db, TxAdapterOptions{TxOptions: opts})
+}
+
+// PutTxOnContextWithRollbackStatus adapter is like PutTxOnContext except that the transaction
+// is rolled back if the handler responds with the given status or greater.
+func PutTxOnContextWithRollbackStatus(db *sql.DB, rollbackStatus int) Adapter {
+	return PutTxOnContextWithOptions(db, TxAdapterOptions{RollbackStatus: rollbackStatus})
+}
+
+// TxAdapterOptions configure PutTxOnContextWithOptions.
+type TxAdapterOptions struct {
+	// TxOptions are the options the transaction is started with, e.g. to set the isolation level
+	// or make the transaction read-only. If nil, the driver's defaults are used.
+	TxOptions *sql.TxOptions
+	// RollbackStatus is the status at or above which the transaction is rolled back.
+	// The default is http.StatusInternalServerError.
+	RollbackStatus int
+}
+
+// PutTxOnContextWithOptions adapter is like PutTxOnContext except that the transaction is configured with the options.
+// The transaction is tied to the request's context, so it is rolled back if the request is cancelled.
+func PutTxOnContextWithOptions(db *sql.DB, opts TxAdapterOptions) Adapter {
+	rollbackStatus := opts.RollbackStatus
+	if rollbackStatus <= 0 {
+		rollbackStatus = http.StatusInternalServerError
+	}
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tx, err := db.BeginTx(r.Context(), opts.TxOptions)
+			if err != nil {
+				packageLogger().Printf("Error starting transaction: %v\n", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			bw := newBufferingResponseWriter(w, DefaultBufferLimit)
+			defer func() {
+				if e := recover(); e != nil {
+					rollback(tx)
+					panic(e)
+				}
+				if bw.status >= rollbackStatus || r.Context().Err() != nil {
+					rollback(tx)
+				} else if err := tx.Commit(); err != nil {
+					packageLogger().Printf("Error committing transaction: %v\n", err)
+					if !bw.streaming {
+						for name := range w.Header() {
+							delete(w.Header(), name)
+						}
+						http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+						return
+					}
+				}
+				bw.flush()
+			}()
+			h.ServeHTTP(bw, r.WithContext(context.WithValue(r.Context(), txKey, tx)))
+		})
+	}
+}
+
+// TxFromContext returns the transaction put on the context by PutTxOnContext.
+func TxFromContext(ctx context.Context) (*sql.Tx, bool) {
+	tx, ok := ctx.Value(txKey).(*sql.Tx)
+	return tx, ok
+}
+
+func rollback(tx *sql.Tx) {
+	// A transaction whose context was cancelled may already have been rolled back.
+	if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+		packageLogger().Printf("Error rolling back transaction: %v\n", err)
+	}
+}
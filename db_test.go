@@ -0,0 +1,209 @@
+package adaptd
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestTxFromContext(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO users").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	h := PutTxOnContext(db)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tx, ok := TxFromContext(r.Context())
+		if !ok {
+			t.Fatal("Transaction should be on the context")
+		}
+		if _, err := tx.Exec("INSERT INTO users (name) VALUES (?)", "me"); err != nil {
+			t.Error(err)
+		}
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", nil))
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPutTxOnContextPanicRollsBack(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Panic should be passed on after rolling back")
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	}()
+	PutTxOnContext(db)(http.HandlerFunc(handlerPanic)).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", nil))
+}
+
+func TestTxFromContextMissing(t *testing.T) {
+	if _, ok := TxFromContext(httptest.NewRequest(http.MethodGet, "/", nil).Context()); ok {
+		t.Error("Transaction should not be found on a context without one")
+	}
+}
+
+func TestPutTxOnContextCommitsOnSuccess(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	mock.ExpectBegin()
+	mock.ExpectCommit()
+
+	w := httptest.NewRecorder()
+	PutTxOnContext(db)(http.HandlerFunc(handlerTester)).ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", nil))
+	if w.Code != http.StatusOK {
+		t.Error("Successful request should not give an error")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPutTxOnContextErrorStatusRollsBack(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+
+	failing := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "failed", http.StatusInternalServerError)
+	})
+	PutTxOnContext(db)(failing).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", nil))
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPutTxOnContextWithRollbackStatus(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+
+	badRequest := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad request", http.StatusBadRequest)
+	})
+	PutTxOnContextWithRollbackStatus(db, http.StatusBadRequest)(badRequest).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", nil))
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPutTxOnContextWithOptions(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+
+	notFound := http.HandlerFunc(http.NotFound)
+	opts := TxAdapterOptions{TxOptions: &sql.TxOptions{ReadOnly: true}, RollbackStatus: http.StatusNotFound}
+	PutTxOnContextWithOptions(db, opts)(notFound).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPutTxOnContextCommitFails(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	mock.ExpectBegin()
+	mock.ExpectCommit().WillReturnError(errors.New("connection lost"))
+
+	created := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", "/users/1")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("created"))
+	})
+	w := httptest.NewRecorder()
+	PutTxOnContext(db)(created).ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", nil))
+	if w.Code != http.StatusInternalServerError || w.Header().Get("Location") != "" || strings.Contains(w.Body.String(), "created") {
+		t.Errorf("Failed commit should give an error instead of the response, got %v %q", w.Code, w.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPutTxOnContextOptsCancelledRequestRollsBack(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cancelling := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cancel()
+	})
+	req := httptest.NewRequest(http.MethodPost, "/", nil).WithContext(ctx)
+	PutTxOnContextOpts(db, &sql.TxOptions{Isolation: sql.LevelSerializable})(cancelling).ServeHTTP(httptest.NewRecorder(), req)
+
+	// The rollback triggered by the cancelled context happens asynchronously.
+	deadline := time.Now().Add(time.Second)
+	for err = mock.ExpectationsWereMet(); err != nil && time.Now().Before(deadline); err = mock.ExpectationsWereMet() {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPutTxOnContextOptsCancelledBeforeBegin(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	checkNumber = 0
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w := httptest.NewRecorder()
+	PutTxOnContextOpts(db, nil)(http.HandlerFunc(handlerTester)).ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", nil).WithContext(ctx))
+	if w.Code != http.StatusInternalServerError || checkNumber != 0 {
+		t.Error("Cancelled request should not start a transaction")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,246 @@
+package adaptd
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// SecurityOptions are the headers added by SecureHeaders.
+// Any header whose option is empty is not added.
+type SecurityOptions struct {
+	// StrictTransportSecurity is the value of the Strict-Transport-Security header, e.g. "max-age=63072000; includeSubDomains".
+	// It is only added to HTTPS responses.
+	StrictTransportSecurity string
+	// AllowXForwardedProto allows 'X-Forwarded-Proto == "https"' to indicate that the request was made with https protocol.
+	AllowXForwardedProto bool
+	// ContentTypeNosniff adds the header X-Content-Type-Options: nosniff.
+	ContentTypeNosniff bool
+	// FrameOptions is the value of the X-Frame-Options header, e.g. "DENY" or "SAMEORIGIN".
+	FrameOptions string
+	// ReferrerPolicy is the value of the Referrer-Policy header, e.g. "strict-origin-when-cross-origin".
+	ReferrerPolicy string
+	// ContentSecurityPolicy is the value of the Content-Security-Policy header.
+	ContentSecurityPolicy string
+}
+
+// SecureHeaders adapter adds the security related headers in the options before calling the handler.
+func SecureHeaders(opts SecurityOptions) Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header := w.Header()
+			if opts.StrictTransportSecurity != "" && isHTTPS(r, opts.AllowXForwardedProto) {
+				header.Set("Strict-Transport-Security", opts.StrictTransportSecurity)
+			}
+			if opts.ContentTypeNosniff {
+				header.Set("X-Content-Type-Options", "nosniff")
+			}
+			if opts.FrameOptions != "" {
+				header.Set("X-Frame-Options", opts.FrameOptions)
+			}
+			if opts.ReferrerPolicy != "" {
+				header.Set("Referrer-Policy", opts.ReferrerPolicy)
+			}
+			if opts.ContentSecurityPolicy != "" {
+				header.Set("Content-Security-Policy", opts.ContentSecurityPolicy)
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// FrameOptions adapter adds the X-Frame-Options header with the value before calling the handler.
+// The accepted values are DENY, SAMEORIGIN, and the obsolete ALLOW-FROM followed by an origin.
+// It panics if the value is not one of these.
+func FrameOptions(value string) Adapter {
+	upper := strings.ToUpper(value)
+	if upper != "DENY" && upper != "SAMEORIGIN" && !(strings.HasPrefix(upper, "ALLOW-FROM ") && len(strings.TrimSpace(value)) > len("ALLOW-FROM ")) {
+		panic(fmt.Sprintf("adaptd: %q is not a valid X-Frame-Options value", value))
+	}
+	return AddHeader("X-Frame-Options", value)
+}
+
+// ReferrerPolicy adapter adds the Referrer-Policy header with the value before calling the handler.
+// The accepted policies are no-referrer, no-referrer-when-downgrade, origin, origin-when-cross-origin,
+// same-origin, strict-origin, strict-origin-when-cross-origin, and unsafe-url.
+// A comma-separated list of policies can be given, in which case browsers use the last one they support.
+// It panics if any policy is not one of these.
+func ReferrerPolicy(value string) Adapter {
+	for _, policy := range strings.Split(value, ",") {
+		switch strings.ToLower(strings.TrimSpace(policy)) {
+		case "no-referrer", "no-referrer-when-downgrade", "origin", "origin-when-cross-origin",
+			"same-origin", "strict-origin", "strict-origin-when-cross-origin", "unsafe-url":
+		default:
+			panic(fmt.Sprintf("adaptd: %q is not a valid Referrer-Policy value", value))
+		}
+	}
+	return AddHeader("Referrer-Policy", value)
+}
+
+// ContentTypeNosniff adapter adds the header X-Content-Type-Options: nosniff before calling the handler,
+// so browsers do not guess a content type other than the one given.
+func ContentTypeNosniff() Adapter {
+	return AddHeader("X-Content-Type-Options", "nosniff")
+}
+
+// DefaultContentType adapter sets the Content-Type header to ct when the response is written,
+// unless the handler has already set one. This keeps clients from guessing the type of the response.
+func DefaultContentType(ct string) Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h.ServeHTTP(&beforeHeaderWriter{ResponseWriter: w, hook: func(header http.Header) {
+				if _, ok := header["Content-Type"]; !ok {
+					header.Set("Content-Type", ct)
+				}
+			}}, r)
+		})
+	}
+}
+
+// HardenCookies adapter adds the HttpOnly attribute, and the Secure attribute for HTTPS requests,
+// to every Set-Cookie header of the response that does not already have them. The headers are changed
+// just before they are written, so cookies set by any handler are hardened.
+func HardenCookies() Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			secure := isHTTPS(r, false)
+			cw := &beforeHeaderWriter{ResponseWriter: w, hook: func(header http.Header) {
+				cookies := header["Set-Cookie"]
+				for i, cookie := range cookies {
+					cookies[i] = hardenCookie(cookie, secure)
+				}
+			}}
+			h.ServeHTTP(cw, r)
+			if !cw.wroteHeader {
+				// The handler wrote nothing, but the cookies it set must still be hardened.
+				cw.WriteHeader(http.StatusOK)
+			}
+		})
+	}
+}
+
+// hardenCookie adds the HttpOnly attribute, and the Secure attribute if secure is true, to the Set-Cookie value
+// if they are missing.
+func hardenCookie(cookie string, secure bool) string {
+	hasSecure, hasHTTPOnly := false, false
+	// The first part is the name and value, the rest are the attributes.
+	for _, attr := range strings.Split(cookie, ";")[1:] {
+		name, _, _ := strings.Cut(strings.TrimSpace(attr), "=")
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "secure":
+			hasSecure = true
+		case "httponly":
+			hasHTTPOnly = true
+		}
+	}
+	if secure && !hasSecure {
+		cookie += "; Secure"
+	}
+	if !hasHTTPOnly {
+		cookie += "; HttpOnly"
+	}
+	return cookie
+}
+
+// CSPNonce adapter generates a random nonce for each request, stores it on the request's context,
+// and substitutes it for every %nonce% in the Content-Security-Policy and Content-Security-Policy-Report-Only
+// headers of the response, e.g. "script-src 'nonce-%nonce%'" set by SecureHeaders or the handler.
+// The nonce can be retrieved by handlers with CSPNonceFromContext to add it to inline scripts and styles.
+func CSPNonce() Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			b := make([]byte, 16)
+			if _, err := rand.Read(b); err != nil {
+				packageLogger().Printf("Failed to generate a CSP nonce: %v\n", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			nonce := base64.StdEncoding.EncodeToString(b)
+			nw := &beforeHeaderWriter{ResponseWriter: w, hook: func(header http.Header) {
+				for _, name := range []string{"Content-Security-Policy", "Content-Security-Policy-Report-Only"} {
+					policies := header[name]
+					for i, policy := range policies {
+						policies[i] = strings.ReplaceAll(policy, "%nonce%", nonce)
+					}
+				}
+			}}
+			h.ServeHTTP(nw, r.WithContext(context.WithValue(r.Context(), cspNonceKey, nonce)))
+			if !nw.wroteHeader {
+				nw.WriteHeader(http.StatusOK)
+			}
+		})
+	}
+}
+
+// CSPNonceFromContext returns the nonce stored on the context by CSPNonce.
+func CSPNonceFromContext(ctx context.Context) (string, bool) {
+	nonce, ok := ctx.Value(cspNonceKey).(string)
+	return nonce, ok
+}
+
+// RequireHeaders adapter requires the request headers to be present and not empty.
+// Requests missing one are given a http.StatusBadRequest error naming the first missing header.
+func RequireHeaders(names ...string) Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, name := range names {
+				if r.Header.Get(name) == "" {
+					http.Error(w, fmt.Sprintf("Missing header %q", http.CanonicalHeaderKey(name)), http.StatusBadRequest)
+					return
+				}
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireHeaderValue adapter requires the request header to have exactly the value.
+// Requests with a different or missing value are given a http.StatusBadRequest error naming the header.
+// The values are compared in constant time, so the value can be a shared secret.
+func RequireHeaderValue(name, value string) Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if subtle.ConstantTimeCompare([]byte(r.Header.Get(name)), []byte(value)) != 1 {
+				http.Error(w, fmt.Sprintf("Invalid header %q", http.CanonicalHeaderKey(name)), http.StatusBadRequest)
+				return
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// AddVary adapter adds the fields to the Vary header before calling the handler.
+// Fields already in the Vary header, compared case-insensitively, are not added again.
+func AddVary(fields ...string) Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			addVary(w.Header(), fields...)
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// addVary adds the fields to the Vary header that are not already there.
+func addVary(header http.Header, fields ...string) {
+	var vary []string
+	seen := make(map[string]bool)
+	for _, value := range header.Values("Vary") {
+		for _, field := range strings.Split(value, ",") {
+			if field = strings.TrimSpace(field); field != "" && !seen[strings.ToLower(field)] {
+				seen[strings.ToLower(field)] = true
+				vary = append(vary, field)
+			}
+		}
+	}
+	for _, field := range fields {
+		if !seen[strings.ToLower(field)] {
+			seen[strings.ToLower(field)] = true
+			vary = append(vary, field)
+		}
+	}
+	header.Set("Vary", strings.Join(vary, ", "))
+}
@@ -0,0 +1,226 @@
+package adaptd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var testSecurityOptions = SecurityOptions{
+	StrictTransportSecurity: "max-age=63072000; includeSubDomains",
+	ContentTypeNosniff:      true,
+	FrameOptions:            "DENY",
+	ReferrerPolicy:          "no-referrer",
+}
+
+func TestSecureHeadersHTTPS(t *testing.T) {
+	ts := httptest.NewTLSServer(SecureHeaders(testSecurityOptions)(http.HandlerFunc(handlerTester)))
+	defer ts.Close()
+
+	resp, err := ts.Client().Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"Strict-Transport-Security": "max-age=63072000; includeSubDomains",
+		"X-Content-Type-Options":    "nosniff",
+		"X-Frame-Options":           "DENY",
+		"Referrer-Policy":           "no-referrer",
+	}
+	for name, value := range expected {
+		if resp.Header.Get(name) != value {
+			t.Errorf("Header %v should be %q, got %q", name, value, resp.Header.Get(name))
+		}
+	}
+	if _, ok := resp.Header["Content-Security-Policy"]; ok {
+		t.Error("Empty Content-Security-Policy option should not add the header")
+	}
+}
+
+func TestSecureHeadersHTTP(t *testing.T) {
+	ts := httptest.NewServer(SecureHeaders(testSecurityOptions)(http.HandlerFunc(handlerTester)))
+	defer ts.Close()
+
+	resp, err := ts.Client().Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := resp.Header["Strict-Transport-Security"]; ok {
+		t.Error("Strict-Transport-Security should not be sent over HTTP")
+	}
+	if resp.Header.Get("X-Frame-Options") != "DENY" {
+		t.Error("Other security headers should be sent over HTTP")
+	}
+}
+
+func TestAddVary(t *testing.T) {
+	h := Adapt(http.HandlerFunc(handlerTester), AddHeader("Vary", "Accept"), AddVary("Accept-Encoding", "accept"))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if vary := w.Header().Values("Vary"); len(vary) != 1 || vary[0] != "Accept, Accept-Encoding" {
+		t.Errorf("Vary should be added to, got %q", vary)
+	}
+}
+
+func TestSecurityHeaderAdapters(t *testing.T) {
+	for header, a := range map[string]struct {
+		adapter Adapter
+		value   string
+	}{
+		"X-Frame-Options":        {FrameOptions("SAMEORIGIN"), "SAMEORIGIN"},
+		"Referrer-Policy":        {ReferrerPolicy("no-referrer, strict-origin-when-cross-origin"), "no-referrer, strict-origin-when-cross-origin"},
+		"X-Content-Type-Options": {ContentTypeNosniff(), "nosniff"},
+	} {
+		w := httptest.NewRecorder()
+		a.adapter(http.HandlerFunc(handlerTester)).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		if w.Header().Get(header) != a.value {
+			t.Errorf("%v should be %q, got %q", header, a.value, w.Header().Get(header))
+		}
+	}
+}
+
+func TestFrameOptionsValidation(t *testing.T) {
+	for value, valid := range map[string]bool{
+		"DENY":                           true,
+		"sameorigin":                     true,
+		"ALLOW-FROM https://example.com": true,
+		"ALLOW-FROM ":                    false,
+		"ALLOWALL":                       false,
+		"":                               false,
+	} {
+		func() {
+			defer func() {
+				if panicked := recover() != nil; panicked == valid {
+					t.Errorf("FrameOptions(%q) valid should be %v", value, valid)
+				}
+			}()
+			FrameOptions(value)
+		}()
+	}
+}
+
+func TestReferrerPolicyValidation(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Invalid Referrer-Policy should panic")
+		}
+	}()
+	ReferrerPolicy("no-referrer, everywhere")
+}
+
+func TestDefaultContentType(t *testing.T) {
+	a := DefaultContentType("application/json")
+
+	w := httptest.NewRecorder()
+	a(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	})).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("Handler without a Content-Type should get the default, got %q", w.Header().Get("Content-Type"))
+	}
+
+	w = httptest.NewRecorder()
+	a(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/csv")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("a,b"))
+	})).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Header().Get("Content-Type") != "text/csv" || w.Code != http.StatusCreated {
+		t.Errorf("Handler's Content-Type should be kept, got %q", w.Header().Get("Content-Type"))
+	}
+}
+
+func TestHardenCookies(t *testing.T) {
+	h := HardenCookies()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc", Path: "/"})
+		w.Header().Add("Set-Cookie", "theme=dark; secure; HTTPONLY")
+		w.Write([]byte("hello"))
+	}))
+
+	for target, expected := range map[string][]string{
+		"https://example.com/": {"session=abc; Path=/; Secure; HttpOnly", "theme=dark; secure; HTTPONLY"},
+		"http://example.com/":  {"session=abc; Path=/; HttpOnly", "theme=dark; secure; HTTPONLY"},
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		if cookies := w.Result().Header.Values("Set-Cookie"); !reflect.DeepEqual(cookies, expected) {
+			t.Errorf("Cookies for %v should be %q, got %q", target, expected, cookies)
+		}
+	}
+
+	h = HardenCookies()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc"})
+	}))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "https://example.com/", nil))
+	if cookie := w.Result().Header.Get("Set-Cookie"); cookie != "session=abc; Secure; HttpOnly" {
+		t.Errorf("Cookies should be hardened when the handler writes nothing, got %q", cookie)
+	}
+}
+
+func TestCSPNonce(t *testing.T) {
+	var nonces []string
+	h := SecureHeaders(SecurityOptions{ContentSecurityPolicy: "script-src 'nonce-%nonce%'; style-src 'nonce-%nonce%'"})(
+		CSPNonce()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			nonce, ok := CSPNonceFromContext(r.Context())
+			if !ok || nonce == "" {
+				t.Error("Nonce should be on the context")
+			}
+			nonces = append(nonces, nonce)
+			w.Write([]byte(`<script nonce="` + nonce + `"></script>`))
+		})))
+
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		expected := "script-src 'nonce-" + nonces[i] + "'; style-src 'nonce-" + nonces[i] + "'"
+		if csp := w.Header().Get("Content-Security-Policy"); csp != expected {
+			t.Errorf("Content-Security-Policy should have the nonce on the context, got %q", csp)
+		}
+	}
+	if nonces[0] == nonces[1] {
+		t.Error("Each request should get a different nonce")
+	}
+}
+
+func TestRequireHeaders(t *testing.T) {
+	h := RequireHeaders("X-Tenant-ID", "x-request-id")(http.HandlerFunc(handlerTester))
+
+	checkNumber = 0
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Tenant-ID", "acme")
+	req.Header.Set("X-Request-ID", "1")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || checkNumber != 1 {
+		t.Error("Request with all the headers should be handled")
+	}
+
+	req.Header.Del("X-Tenant-ID")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "X-Tenant-Id") || checkNumber != 1 {
+		t.Errorf("Request missing a header should get a 400 naming it, got %v %q", w.Code, w.Body.String())
+	}
+}
+
+func TestRequireHeaderValue(t *testing.T) {
+	h := RequireHeaderValue("X-Internal", "yes")(http.HandlerFunc(handlerTester))
+	for value, expected := range map[string]int{
+		"yes": http.StatusOK,
+		"no":  http.StatusBadRequest,
+		"":    http.StatusBadRequest,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if value != "" {
+			req.Header.Set("X-Internal", value)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != expected {
+			t.Errorf("Header value %q should get %v, got %v", value, expected, w.Code)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package adaptd
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+// HealthCheck adapter answers requests to the path by running each of the checks with the request's context.
+// It is HealthCheckNamed with the checks named by their position, e.g. "check 2" for the second check.
+// Use HealthCheckNamed so the failures identify the checks.
+func HealthCheck(path string, checks ...func(context.Context) error) Adapter {
+	named := make([]Check, len(checks))
+	for i, check := range checks {
+		named[i] = Check{Name: "check " + strconv.Itoa(i+1), Func: check}
+	}
+	return HealthCheckNamed(path, named...)
+}
+
+// Check is a named check run by HealthCheckNamed.
+type Check struct {
+	Name string
+	Func func(context.Context) error
+}
+
+// HealthCheckNamed adapter answers requests to the path by running each of the checks with the request's context.
+// If all the checks pass, a http.StatusOK response with the JSON body {"status":"ok"} is given.
+// Otherwise, a http.StatusServiceUnavailable response is given with the names of the failing checks, e.g.
+// {"status":"unavailable","failures":["database"]}. The errors are logged rather than sent,
+// as they may reveal details of the infrastructure. Requests to other paths are passed to the handler.
+func HealthCheckNamed(path string, checks ...Check) Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != path {
+				h.ServeHTTP(w, r)
+				return
+			}
+			result := struct {
+				Status   string   `json:"status"`
+				Failures []string `json:"failures,omitempty"`
+			}{Status: "ok"}
+			for _, check := range checks {
+				if err := check.Func(r.Context()); err != nil {
+					packageLogger().Printf("Health check %v failed: %v\n", check.Name, err)
+					result.Failures = append(result.Failures, check.Name)
+				}
+			}
+			status := http.StatusOK
+			if len(result.Failures) > 0 {
+				result.Status = "unavailable"
+				status = http.StatusServiceUnavailable
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Cache-Control", "no-store")
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(result)
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package adaptd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func passingCheck(context.Context) error { return nil }
+
+func failingCheck(context.Context) error { return errors.New("connection refused") }
+
+func TestHealthCheckPassing(t *testing.T) {
+	checkNumber = 0
+	h := HealthCheckNamed("/healthz", Check{"database", passingCheck}, Check{"cache", passingCheck})(http.HandlerFunc(handlerTester))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if w.Code != http.StatusOK || checkNumber != 0 || w.Body.String() != "{\"status\":\"ok\"}\n" {
+		t.Errorf("Passing checks should give a healthy response, got %v %q", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusOK || checkNumber != 1 {
+		t.Error("Other paths should be passed to the handler")
+	}
+}
+
+func TestHealthCheckFailing(t *testing.T) {
+	var logs bytes.Buffer
+	SetLogger(log.New(&logs, "", 0))
+	defer SetLogger(nil)
+	h := HealthCheckNamed("/healthz", Check{"database", failingCheck}, Check{"cache", passingCheck})(http.HandlerFunc(handlerTester))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	var result struct {
+		Status   string
+		Failures []string
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusServiceUnavailable || result.Status != "unavailable" || len(result.Failures) != 1 || result.Failures[0] != "database" {
+		t.Errorf("Failing check should give an unhealthy response naming it, got %v %q", w.Code, w.Body.String())
+	}
+	if !strings.Contains(logs.String(), "Health check database failed: connection refused") {
+		t.Errorf("Error of the failing check should be logged, got %q", logs.String())
+	}
+}
+
+func TestHealthCheckUnnamed(t *testing.T) {
+	h := HealthCheck("/healthz", passingCheck, failingCheck)(http.HandlerFunc(handlerTester))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if w.Code != http.StatusServiceUnavailable || w.Body.String() != "{\"status\":\"unavailable\",\"failures\":[\"check 2\"]}\n" {
+		t.Errorf("Failing check should be named by its position, got %v %q", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,29 @@
+package adaptd
+
+import (
+	"net/http"
+	"time"
+)
+
+// AfterResponse adapter calls the function after the handler returns with the status code,
+// the time taken, and the number of bytes written for the response.
+// The function is called even if the handler panics, in which case the status is
+// http.StatusInternalServerError unless the handler had already written a status.
+func AfterResponse(fn func(r *http.Request, status int, duration time.Duration, bytes int)) Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			sr := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			start := time.Now()
+			completed := false
+			defer func() {
+				status := sr.status
+				if !completed && !sr.wroteHeader {
+					status = http.StatusInternalServerError
+				}
+				fn(r, status, time.Since(start), sr.bytes)
+			}()
+			h.ServeHTTP(sr, r)
+			completed = true
+		})
+	}
+}
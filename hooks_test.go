@@ -0,0 +1,43 @@
+package adaptd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAfterResponse(t *testing.T) {
+	var status, bytes int
+	var duration time.Duration
+	h := AfterResponse(func(r *http.Request, s int, d time.Duration, b int) {
+		status, duration, bytes = s, d, b
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(10 * time.Millisecond)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("created"))
+	}))
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", nil))
+	if status != http.StatusCreated || bytes != len("created") || duration < 10*time.Millisecond {
+		t.Errorf("Unexpected values passed to function: status %v, duration %v, bytes %v", status, duration, bytes)
+	}
+}
+
+func TestAfterResponseOnPanic(t *testing.T) {
+	called := false
+	status := 0
+	h := AfterResponse(func(r *http.Request, s int, d time.Duration, b int) {
+		called, status = true, s
+	})(http.HandlerFunc(handlerPanic))
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Panic should not be recovered by AfterResponse")
+		}
+		if !called || status != http.StatusInternalServerError {
+			t.Error("Function should be called with an error status when the handler panics")
+		}
+	}()
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+}
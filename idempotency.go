@@ -0,0 +1,208 @@
+package adaptd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// IdempotentResponse is a response stored for an idempotency key.
+type IdempotentResponse struct {
+	Status int
+	Header http.Header
+	Body   []byte
+	// RequestHash is the SHA-256 of the body of the request the response was given for.
+	RequestHash string
+}
+
+// IdempotencyStore stores the responses for idempotency keys. Implementations must be safe for concurrent use.
+type IdempotencyStore interface {
+	// Reserve reserves the key for a request being handled. If a response is stored for the key, it is returned.
+	// If the key is already reserved by a request being handled, reserved is false.
+	Reserve(key string) (resp *IdempotentResponse, reserved bool, err error)
+	// Save stores the response for a reserved key for the ttl.
+	Save(key string, resp *IdempotentResponse, ttl time.Duration) error
+	// Release removes the reservation of a key without storing a response.
+	Release(key string) error
+}
+
+// Idempotency adapter makes retries of POST, PUT, PATCH, and DELETE requests with an Idempotency-Key header safe.
+// It is IdempotencyWithScope with keys scoped to the request's Authorization header, so clients with
+// different credentials do not share keys. Use IdempotencyWithScope if clients are identified in another way, like a cookie.
+func Idempotency(store IdempotencyStore, ttl time.Duration) Adapter {
+	return IdempotencyWithScope(store, ttl, nil)
+}
+
+// IdempotencyWithScope adapter makes retries of POST, PUT, PATCH, and DELETE requests with an Idempotency-Key header safe.
+// The first request with a key is handled and its response is stored for the ttl. Later requests with the key
+// for the same method, path, and scope get the stored response without calling the handler. The scope returned by
+// scopeFunc should identify the client, e.g. the user or credential, so one client is never given another's response.
+// If scopeFunc is nil, the SHA-256 of the request's Authorization header is used.
+// Requests with a key that is being handled are given a http.StatusConflict error, and requests reusing a key with
+// a different body are given a http.StatusUnprocessableEntity error.
+// Server errors are not stored so the request can be retried. Only the headers set by the handler are stored,
+// not those set by adapters before it, which set them again when the response is replayed.
+// The request body and the response are buffered in order to compare and store them.
+func IdempotencyWithScope(store IdempotencyStore, ttl time.Duration, scopeFunc func(*http.Request) string) Adapter {
+	if scopeFunc == nil {
+		scopeFunc = func(r *http.Request) string {
+			if auth := r.Header.Get("Authorization"); auth != "" {
+				return hashString([]byte(auth))
+			}
+			return ""
+		}
+	}
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			idempotencyKey := r.Header.Get("Idempotency-Key")
+			if idempotencyKey == "" || isSafeMethod(r.Method) {
+				h.ServeHTTP(w, r)
+				return
+			}
+			var body []byte
+			if r.Body != nil {
+				var err error
+				if body, err = io.ReadAll(r.Body); err != nil {
+					http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+					return
+				}
+				r.Body.Close()
+				r2 := new(http.Request)
+				*r2 = *r
+				r2.Body = io.NopCloser(bytes.NewReader(body))
+				r = r2
+			}
+			requestHash := hashString(body)
+
+			key := r.Method + " " + r.URL.Path + " " + idempotencyKey
+			if scope := scopeFunc(r); scope != "" {
+				key = scope + " " + key
+			}
+			resp, reserved, err := store.Reserve(key)
+			if err != nil {
+				packageLogger().Printf("Error reserving idempotency key: %v\n", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			if resp != nil {
+				if resp.RequestHash != requestHash {
+					http.Error(w, "The Idempotency-Key was used for a request with a different body", http.StatusUnprocessableEntity)
+					return
+				}
+				for name, values := range resp.Header {
+					w.Header()[name] = append([]string(nil), values...)
+				}
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(resp.Status)
+				w.Write(resp.Body)
+				return
+			}
+			if !reserved {
+				http.Error(w, "A request with this Idempotency-Key is being handled", http.StatusConflict)
+				return
+			}
+
+			saved := false
+			defer func() {
+				if !saved {
+					if err := store.Release(key); err != nil {
+						packageLogger().Printf("Error releasing idempotency key: %v\n", err)
+					}
+				}
+			}()
+			rr := newResponseRecorder()
+			h.ServeHTTP(rr, r)
+			if rr.status < http.StatusInternalServerError {
+				resp := &IdempotentResponse{
+					Status:      rr.status,
+					Header:      rr.header.Clone(),
+					Body:        append([]byte(nil), rr.body.Bytes()...),
+					RequestHash: requestHash,
+				}
+				if err := store.Save(key, resp, ttl); err != nil {
+					packageLogger().Printf("Error saving idempotent response: %v\n", err)
+				} else {
+					saved = true
+				}
+			}
+			for name, values := range rr.header {
+				w.Header()[name] = values
+			}
+			w.WriteHeader(rr.status)
+			w.Write(rr.body.Bytes())
+		})
+	}
+}
+
+// hashString returns the hex-encoded SHA-256 of b.
+func hashString(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// idempotencySweepInterval is how often MemoryIdempotencyStore removes the expired responses.
+const idempotencySweepInterval = time.Minute
+
+// MemoryIdempotencyStore is an IdempotencyStore that keeps responses in memory.
+// Expired responses are removed when their key is reserved again, and all of them at most once a minute.
+type MemoryIdempotencyStore struct {
+	mu        sync.Mutex
+	entries   map[string]*idempotencyEntry
+	lastSweep time.Time
+}
+
+type idempotencyEntry struct {
+	resp    *IdempotentResponse
+	expires time.Time
+}
+
+// NewMemoryIdempotencyStore creates an empty MemoryIdempotencyStore.
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{entries: make(map[string]*idempotencyEntry)}
+}
+
+// Reserve implements IdempotencyStore.
+func (m *MemoryIdempotencyStore) Reserve(key string) (*IdempotentResponse, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	if now.Sub(m.lastSweep) > idempotencySweepInterval {
+		for k, e := range m.entries {
+			// Reserved keys have no response and are removed when they are released.
+			if e.resp != nil && !now.Before(e.expires) {
+				delete(m.entries, k)
+			}
+		}
+		m.lastSweep = now
+	}
+	if e, ok := m.entries[key]; ok {
+		if e.resp == nil {
+			return nil, false, nil
+		}
+		if now.Before(e.expires) {
+			return e.resp, false, nil
+		}
+	}
+	m.entries[key] = &idempotencyEntry{}
+	return nil, true, nil
+}
+
+// Save implements IdempotencyStore.
+func (m *MemoryIdempotencyStore) Save(key string, resp *IdempotentResponse, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries[key] = &idempotencyEntry{resp: resp, expires: time.Now().Add(ttl)}
+	return nil
+}
+
+// Release implements IdempotencyStore.
+func (m *MemoryIdempotencyStore) Release(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.entries, key)
+	return nil
+}
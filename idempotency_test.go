@@ -0,0 +1,188 @@
+package adaptd
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func idempotentRequest(key string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/orders", nil)
+	req.Header.Set("Idempotency-Key", key)
+	return req
+}
+
+func TestIdempotencyReplay(t *testing.T) {
+	calls := 0
+	h := Idempotency(NewMemoryIdempotencyStore(), time.Minute)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("X-Order", strconv.Itoa(calls))
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("order " + strconv.Itoa(calls)))
+	}))
+
+	first := httptest.NewRecorder()
+	h.ServeHTTP(first, idempotentRequest("abc"))
+	if calls != 1 || first.Code != http.StatusCreated || first.Body.String() != "order 1" {
+		t.Fatalf("First request should be handled, got %v %q", first.Code, first.Body.String())
+	}
+
+	second := httptest.NewRecorder()
+	h.ServeHTTP(second, idempotentRequest("abc"))
+	if calls != 1 {
+		t.Error("Repeated key should not call the handler")
+	}
+	if second.Code != http.StatusCreated || second.Body.String() != "order 1" || second.Header().Get("X-Order") != "1" {
+		t.Errorf("Repeated key should replay the response, got %v %q %v", second.Code, second.Body.String(), second.Header())
+	}
+	if second.Header().Get("Idempotent-Replayed") != "true" {
+		t.Error("Replayed response should be marked")
+	}
+
+	other := httptest.NewRecorder()
+	h.ServeHTTP(other, idempotentRequest("def"))
+	if calls != 2 || other.Body.String() != "order 2" {
+		t.Error("New key should call the handler")
+	}
+}
+
+func TestIdempotencyScope(t *testing.T) {
+	calls := 0
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Write([]byte("order " + strconv.Itoa(calls)))
+	})
+	for _, c := range []struct {
+		name    string
+		adapter Adapter
+		header  string
+	}{
+		{"Authorization", Idempotency(NewMemoryIdempotencyStore(), time.Minute), "Authorization"},
+		{"scope", IdempotencyWithScope(NewMemoryIdempotencyStore(), time.Minute, func(r *http.Request) string {
+			return r.Header.Get("X-User")
+		}), "X-User"},
+	} {
+		calls = 0
+		h := c.adapter(handler)
+		for _, user := range []string{"alice", "bob"} {
+			req := idempotentRequest("abc")
+			req.Header.Set(c.header, user)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			if w.Header().Get("Idempotent-Replayed") != "" {
+				t.Errorf("Key used by another client with the %v should not replay its response, got %q", c.name, w.Body.String())
+			}
+		}
+		if calls != 2 {
+			t.Errorf("Each client should have its own keys with the %v, got %v calls", c.name, calls)
+		}
+	}
+}
+
+func TestIdempotencyDifferentBody(t *testing.T) {
+	var bodies []string
+	h := Idempotency(NewMemoryIdempotencyStore(), time.Minute)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(b))
+		w.WriteHeader(http.StatusCreated)
+	}))
+	for _, c := range []struct {
+		body     string
+		expected int
+	}{
+		{`{"item": 1}`, http.StatusCreated},
+		{`{"item": 1}`, http.StatusCreated},
+		{`{"item": 2}`, http.StatusUnprocessableEntity},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(c.body))
+		req.Header.Set("Idempotency-Key", "abc")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != c.expected {
+			t.Errorf("Request with body %v should give %v, got %v", c.body, c.expected, w.Code)
+		}
+	}
+	if len(bodies) != 1 || bodies[0] != `{"item": 1}` {
+		t.Errorf("Handler should be called once and read the body, got %q", bodies)
+	}
+}
+
+func TestIdempotencyConcurrentDuplicate(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	h := Idempotency(NewMemoryIdempotencyStore(), time.Minute)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}))
+
+	done := make(chan struct{})
+	go func() {
+		h.ServeHTTP(httptest.NewRecorder(), idempotentRequest("abc"))
+		close(done)
+	}()
+	<-started
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, idempotentRequest("abc"))
+	if w.Code != http.StatusConflict {
+		t.Errorf("Duplicate key in flight should give %v, got %v", http.StatusConflict, w.Code)
+	}
+	close(release)
+	<-done
+}
+
+func TestIdempotencyServerErrorNotStored(t *testing.T) {
+	calls := 0
+	h := Idempotency(NewMemoryIdempotencyStore(), time.Minute)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), idempotentRequest("abc"))
+	h.ServeHTTP(httptest.NewRecorder(), idempotentRequest("abc"))
+	if calls != 2 {
+		t.Error("Server errors should not be replayed")
+	}
+}
+
+func TestIdempotencyIgnoresSafeMethods(t *testing.T) {
+	checkNumber = 0
+	h := Idempotency(NewMemoryIdempotencyStore(), time.Minute)(http.HandlerFunc(handlerTester))
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/orders", nil)
+		req.Header.Set("Idempotency-Key", "abc")
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	if checkNumber != 2 {
+		t.Error("GET requests should always be handled")
+	}
+}
+
+func TestIdempotencyStoresHandlerHeaders(t *testing.T) {
+	store := NewMemoryIdempotencyStore()
+	h := Adapt(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Order", "1")
+		w.WriteHeader(http.StatusCreated)
+	}), AddHeader("X-Request-Id", "first"), Idempotency(store, time.Minute))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, idempotentRequest("abc"))
+	if w.Header().Get("X-Order") != "1" || w.Header().Get("X-Request-Id") != "first" {
+		t.Errorf("First response should have every header, got %v", w.Header())
+	}
+	resp, _, _ := store.Reserve("POST /orders abc")
+	if resp == nil || resp.Header.Get("X-Order") != "1" || resp.Header.Get("X-Request-Id") != "" {
+		t.Errorf("Only the headers set by the handler should be stored, got %v", resp)
+	}
+}
+
+func TestMemoryIdempotencyStoreSweepsExpired(t *testing.T) {
+	store := NewMemoryIdempotencyStore()
+	store.Save("old", &IdempotentResponse{Status: http.StatusCreated}, -time.Second)
+	store.Reserve("pending")
+	if _, ok := store.entries["old"]; ok || len(store.entries) != 1 {
+		t.Errorf("Expired responses should be removed and reservations kept, got %v entries", len(store.entries))
+	}
+}
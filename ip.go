@@ -0,0 +1,156 @@
+package adaptd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// RealIP adapter determines the IP address of the client for requests forwarded by the trusted proxies.
+// If the request comes directly from a trusted proxy, the client is the right-most address in the
+// X-Forwarded-For header that is not a trusted proxy, or the X-Real-IP header if there is no X-Forwarded-For header.
+// The handler is given a copy of the request whose RemoteAddr is the client's address with port 0.
+// Headers on requests that do not come from a trusted proxy are ignored so they cannot be spoofed.
+// The client's address can be retrieved by handlers with RealIPFromContext.
+func RealIP(trustedProxies []net.IPNet) Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := remoteIP(r)
+			if ip == nil {
+				h.ServeHTTP(w, r)
+				return
+			}
+			var client net.IP
+			if ipInNets(ip, trustedProxies) {
+				client = forwardedClientIP(r, trustedProxies)
+			}
+			if client == nil {
+				h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), realIPKey, ip)))
+				return
+			}
+			// The direct peer is kept so adapters checking for trusted proxies still see the proxy.
+			ctx := context.WithValue(r.Context(), realIPKey, client)
+			if _, ok := ctx.Value(peerIPKey).(net.IP); !ok {
+				ctx = context.WithValue(ctx, peerIPKey, ip)
+			}
+			forwarded := r.WithContext(ctx)
+			forwarded.RemoteAddr = net.JoinHostPort(client.String(), "0")
+			h.ServeHTTP(w, forwarded)
+		})
+	}
+}
+
+// RealIPFromContext returns the IP address of the client stored on the context by RealIP.
+func RealIPFromContext(ctx context.Context) (net.IP, bool) {
+	ip, ok := ctx.Value(realIPKey).(net.IP)
+	return ip, ok
+}
+
+// AllowIPs adapter only allows requests from clients whose IP address is in one of the CIDRs, e.g. "10.0.0.0/8".
+// Single IP addresses are also accepted. The client's IP address determined by RealIP is used if it is available.
+// Other requests are given a http.StatusForbidden error. It panics if a CIDR is malformed.
+func AllowIPs(cidrs ...string) Adapter {
+	nets := mustParseNets(cidrs)
+	return OnCheck(func(w http.ResponseWriter, r *http.Request) bool {
+		ip := clientIP(r)
+		return ip != nil && ipInNets(ip, nets)
+	}, forbiddenHandler(), "Request from an IP address that is not allowed")
+}
+
+// DenyIPs adapter gives a http.StatusForbidden error to requests from clients whose IP address is in
+// one of the CIDRs, e.g. "192.0.2.0/24". Single IP addresses are also accepted.
+// The client's IP address determined by RealIP is used if it is available. It panics if a CIDR is malformed.
+func DenyIPs(cidrs ...string) Adapter {
+	nets := mustParseNets(cidrs)
+	return OnCheck(func(w http.ResponseWriter, r *http.Request) bool {
+		ip := clientIP(r)
+		return ip == nil || !ipInNets(ip, nets)
+	}, forbiddenHandler(), "Request from an IP address that is denied")
+}
+
+// mustParseNets parses the CIDRs, treating single IP addresses as a network of one address.
+func mustParseNets(cidrs []string) []net.IPNet {
+	nets := make([]net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if ip := net.ParseIP(cidr); ip != nil {
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(fmt.Sprintf("adaptd: %q is not a valid CIDR", cidr))
+		}
+		nets = append(nets, *n)
+	}
+	return nets
+}
+
+func forbiddenHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+	})
+}
+
+// clientIP returns the IP address of the client, using the one determined by RealIP if it is available.
+func clientIP(r *http.Request) net.IP {
+	if ip, ok := RealIPFromContext(r.Context()); ok {
+		return ip
+	}
+	return remoteIP(r)
+}
+
+// remoteIP returns the IP address of the direct peer of the request.
+func remoteIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+// peerIP returns the IP address of the direct peer of the request, even if RealIP has replaced r.RemoteAddr
+// with the address of the client. It should be used to check whether the request comes from a trusted proxy.
+func peerIP(r *http.Request) net.IP {
+	if ip, ok := r.Context().Value(peerIPKey).(net.IP); ok {
+		return ip
+	}
+	return remoteIP(r)
+}
+
+// forwardedClientIP returns the client's IP address from the forwarding headers.
+func forwardedClientIP(r *http.Request, trustedProxies []net.IPNet) net.IP {
+	var forwarded []string
+	for _, value := range r.Header.Values("X-Forwarded-For") {
+		forwarded = append(forwarded, strings.Split(value, ",")...)
+	}
+	if len(forwarded) == 0 {
+		return net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP")))
+	}
+	// Addresses are appended by each proxy, so work back from the right until an untrusted address is found.
+	var ip net.IP
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		ip = net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if ip == nil {
+			return nil
+		}
+		if !ipInNets(ip, trustedProxies) {
+			return ip
+		}
+	}
+	return ip
+}
+
+func ipInNets(ip net.IP, nets []net.IPNet) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,141 @@
+package adaptd
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func mustParseCIDRs(t *testing.T, cidrs ...string) []net.IPNet {
+	var nets []net.IPNet
+	for _, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nets = append(nets, *n)
+	}
+	return nets
+}
+
+func realIPRecorder(remoteAddr *string, ip *net.IP) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*remoteAddr = r.RemoteAddr
+		*ip, _ = RealIPFromContext(r.Context())
+	})
+}
+
+func TestRealIPUntrustedPeer(t *testing.T) {
+	var remoteAddr string
+	var ip net.IP
+	h := RealIP(mustParseCIDRs(t, "10.0.0.0/8"))(realIPRecorder(&remoteAddr, &ip))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "203.0.113.7:4321"
+	req.Header.Set("X-Forwarded-For", "198.51.100.1")
+	req.Header.Set("X-Real-IP", "198.51.100.1")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	if remoteAddr != "203.0.113.7:4321" || !ip.Equal(net.ParseIP("203.0.113.7")) {
+		t.Errorf("Spoofed headers from an untrusted peer should be ignored, got %v and %v", remoteAddr, ip)
+	}
+}
+
+func TestRealIPTrustedProxy(t *testing.T) {
+	var remoteAddr string
+	var ip net.IP
+	h := RealIP(mustParseCIDRs(t, "10.0.0.0/8"))(realIPRecorder(&remoteAddr, &ip))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "10.0.0.2:4321"
+	req.Header.Set("X-Forwarded-For", "192.0.2.99, 198.51.100.1, 10.0.0.3")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	if remoteAddr != "198.51.100.1:0" || !ip.Equal(net.ParseIP("198.51.100.1")) {
+		t.Errorf("Forwarded client from a trusted proxy should be honored, got %v and %v", remoteAddr, ip)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "10.0.0.2:4321"
+	req.Header.Set("X-Real-IP", "198.51.100.2")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	if remoteAddr != "198.51.100.2:0" || !ip.Equal(net.ParseIP("198.51.100.2")) {
+		t.Errorf("X-Real-IP from a trusted proxy should be honored, got %v and %v", remoteAddr, ip)
+	}
+}
+
+func TestRealIPDoesNotModifyRequest(t *testing.T) {
+	var remoteAddr string
+	var ip net.IP
+	h := RealIP(mustParseCIDRs(t, "10.0.0.0/8"))(realIPRecorder(&remoteAddr, &ip))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "10.0.0.2:4321"
+	req.Header.Set("X-Forwarded-For", "2001:db8::1")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	if req.RemoteAddr != "10.0.0.2:4321" {
+		t.Errorf("Caller's request should not be modified, got %v", req.RemoteAddr)
+	}
+	if remoteAddr != "[2001:db8::1]:0" {
+		t.Errorf("Handler's request should have the client's address with a port, got %v", remoteAddr)
+	}
+}
+
+func TestAllowIPs(t *testing.T) {
+	h := AllowIPs("10.0.0.0/8", "192.0.2.1")(http.HandlerFunc(handlerTester))
+	for remoteAddr, expected := range map[string]int{
+		"10.1.2.3:1234":   http.StatusOK,
+		"192.0.2.1:1234":  http.StatusOK,
+		"192.0.2.2:1234":  http.StatusForbidden,
+		"203.0.113.5:999": http.StatusForbidden,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != expected {
+			t.Errorf("Request from %v should get %v, got %v", remoteAddr, expected, w.Code)
+		}
+	}
+}
+
+func TestAllowIPsWithRealIP(t *testing.T) {
+	h := Adapt(http.HandlerFunc(handlerTester), RealIP(mustParseCIDRs(t, "10.0.0.0/8")), AllowIPs("192.0.2.0/24"))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("X-Forwarded-For", "192.0.2.7")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("Client IP from RealIP should be allowed, got %v", w.Code)
+	}
+}
+
+func TestDenyIPs(t *testing.T) {
+	h := DenyIPs("192.0.2.0/24", "2001:db8::/32")(http.HandlerFunc(handlerTester))
+	for remoteAddr, expected := range map[string]int{
+		"192.0.2.9:1234":     http.StatusForbidden,
+		"[2001:db8::1]:1234": http.StatusForbidden,
+		"10.1.2.3:1234":      http.StatusOK,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != expected {
+			t.Errorf("Request from %v should get %v, got %v", remoteAddr, expected, w.Code)
+		}
+	}
+}
+
+func TestAllowIPsMalformedCIDR(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Malformed CIDR should panic")
+		}
+	}()
+	AllowIPs("10.0.0.0/33")
+}
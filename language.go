@@ -0,0 +1,37 @@
+package adaptd
+
+import (
+	"context"
+	"net/http"
+
+	"golang.org/x/text/language"
+)
+
+// Language adapter chooses the supported language that best matches the request's Accept-Language header
+// and sets the Content-Language header to it. If none match, the first supported language is used.
+// The language can be retrieved by handlers with LanguageFromContext. It panics if no languages are supported.
+func Language(supported []language.Tag) Adapter {
+	if len(supported) == 0 {
+		panic("adaptd: Language needs at least one supported language")
+	}
+	matcher := language.NewMatcher(supported)
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tag := supported[0]
+			if requested, _, err := language.ParseAcceptLanguage(r.Header.Get("Accept-Language")); err == nil && len(requested) > 0 {
+				if _, i, confidence := matcher.Match(requested...); confidence != language.No {
+					tag = supported[i]
+				}
+			}
+			addVary(w.Header(), "Accept-Language")
+			w.Header().Set("Content-Language", tag.String())
+			h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), languageKey, tag)))
+		})
+	}
+}
+
+// LanguageFromContext returns the language stored on the context by Language.
+func LanguageFromContext(ctx context.Context) (language.Tag, bool) {
+	tag, ok := ctx.Value(languageKey).(language.Tag)
+	return tag, ok
+}
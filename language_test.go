@@ -0,0 +1,42 @@
+package adaptd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/text/language"
+)
+
+func TestLanguage(t *testing.T) {
+	var chosen language.Tag
+	h := Language([]language.Tag{language.English, language.French, language.German})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ok bool
+		if chosen, ok = LanguageFromContext(r.Context()); !ok {
+			t.Error("Language should be on the context")
+		}
+	}))
+
+	for acceptLanguage, expected := range map[string]language.Tag{
+		"fr":                 language.French,
+		"de-CH, fr;q=0.8":    language.German,
+		"ja, fr;q=0.5":       language.French,
+		"ja":                 language.English,
+		"":                   language.English,
+		"not a language tag": language.English,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept-Language", acceptLanguage)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if chosen != expected || w.Header().Get("Content-Language") != expected.String() {
+			t.Errorf("Accept-Language %q should choose %v, got %v and Content-Language %q", acceptLanguage, expected, chosen, w.Header().Get("Content-Language"))
+		}
+	}
+}
+
+func TestLanguageFromContextMissing(t *testing.T) {
+	if _, ok := LanguageFromContext(httptest.NewRequest(http.MethodGet, "/", nil).Context()); ok {
+		t.Error("Context without a language should not have one")
+	}
+}
@@ -0,0 +1,137 @@
+package adaptd
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// LimitConcurrency adapter limits the number of requests being handled at the same time to max.
+// Requests wait up to queueTimeout for another request to finish; if queueTimeout is not positive, they do not wait.
+// If none finish in time, or the client goes away while waiting,
+// a http.StatusServiceUnavailable error is given with a Retry-After header.
+// It panics if max is not positive, as no request could ever be handled.
+func LimitConcurrency(max int, queueTimeout time.Duration) Adapter {
+	if max <= 0 {
+		panic(fmt.Sprintf("adaptd: LimitConcurrency needs a positive max, got %v", max))
+	}
+	slots := make(chan struct{}, max)
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case slots <- struct{}{}:
+			default:
+				if queueTimeout <= 0 {
+					ServiceUnavailable(queueTimeout).ServeHTTP(w, r)
+					return
+				}
+				timer := time.NewTimer(queueTimeout)
+				defer timer.Stop()
+				select {
+				case slots <- struct{}{}:
+				case <-timer.C:
+					ServiceUnavailable(queueTimeout).ServeHTTP(w, r)
+					return
+				case <-r.Context().Done():
+					ServiceUnavailable(queueTimeout).ServeHTTP(w, r)
+					return
+				}
+			}
+			defer func() { <-slots }()
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// rateLimiterIdleTimeout is how long a key's limiter is kept after its last request.
+const rateLimiterIdleTimeout = 10 * time.Minute
+
+// RateLimitPerRoute adapter limits each key to limit requests per second with bursts of up to burst requests.
+// The key returned by keyFunc should combine the route and the client so each route has its own budget,
+// e.g. /login can be limited more strictly than /search. If keyFunc is nil, the URL path and the client's IP address are used.
+// Requests over the limit are given a http.StatusTooManyRequests error with a Retry-After header.
+// The limiters of keys without a request in the last 10 minutes are removed.
+func RateLimitPerRoute(limit rate.Limit, burst int, keyFunc func(*http.Request) string) Adapter {
+	if keyFunc == nil {
+		keyFunc = func(r *http.Request) string {
+			return r.URL.Path + " " + clientIP(r).String()
+		}
+	}
+	limiters := &rateLimiters{limit: limit, burst: burst, entries: make(map[string]*rateLimiterEntry)}
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			reservation := limiters.get(keyFunc(r), time.Now()).Reserve()
+			if delay := reservation.Delay(); !reservation.OK() || delay > 0 {
+				reservation.Cancel()
+				setRetryAfter(w, delay)
+				http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+				return
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// rateLimiters holds a limiter for each key, removing the idle ones.
+type rateLimiters struct {
+	limit     rate.Limit
+	burst     int
+	mu        sync.Mutex
+	entries   map[string]*rateLimiterEntry
+	lastSweep time.Time
+}
+
+type rateLimiterEntry struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+func (l *rateLimiters) get(key string, now time.Time) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.lastSweep) > rateLimiterIdleTimeout {
+		for k, e := range l.entries {
+			if now.Sub(e.lastSeen) > rateLimiterIdleTimeout {
+				delete(l.entries, k)
+			}
+		}
+		l.lastSweep = now
+	}
+	e, ok := l.entries[key]
+	if !ok {
+		e = &rateLimiterEntry{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.entries[key] = e
+	}
+	e.lastSeen = now
+	return e.limiter
+}
+
+// ServiceUnavailable returns a handler that gives a http.StatusServiceUnavailable error
+// with a Retry-After header of the duration in seconds, rounded up to at least a second.
+// The response is marked with Cache-Control: no-store so caches do not keep it.
+// It is used by the adapters that shed load, so their responses are consistent.
+func ServiceUnavailable(retryAfter time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setRetryAfter(w, retryAfter)
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+	})
+}
+
+// setRetryAfter sets the Retry-After header, and Cache-Control so the response is not cached.
+func setRetryAfter(w http.ResponseWriter, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", retryAfterSeconds(retryAfter))
+	w.Header().Set("Cache-Control", "no-store")
+}
+
+// retryAfterSeconds formats the duration as a Retry-After header value, rounding up to at least a second.
+func retryAfterSeconds(d time.Duration) string {
+	seconds := int64((d + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	return strconv.FormatInt(seconds, 10)
+}
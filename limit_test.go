@@ -0,0 +1,171 @@
+package adaptd
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestLimitConcurrency(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	h := LimitConcurrency(2, 50*time.Millisecond)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+	}))
+
+	var wg sync.WaitGroup
+	codes := make([]int, 2)
+	for i := range codes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+			codes[i] = w.Code
+		}(i)
+	}
+	// Both requests must be running at the same time for this to finish.
+	<-started
+	<-started
+
+	w := httptest.NewRecorder()
+	start := time.Now()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "1" {
+		t.Errorf("Request over the limit should be unavailable, got %v", w.Code)
+	}
+	if time.Since(start) < 50*time.Millisecond {
+		t.Error("Request over the limit should wait for the queue timeout")
+	}
+
+	close(release)
+	wg.Wait()
+	for _, code := range codes {
+		if code != http.StatusOK {
+			t.Errorf("Requests under the limit should succeed, got %v", code)
+		}
+	}
+
+	go func() { <-started }()
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusOK {
+		t.Error("Slots should be released after requests finish")
+	}
+}
+
+func TestLimitConcurrencyNonPositiveMax(t *testing.T) {
+	for _, max := range []int{0, -1} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Max of %v should panic", max)
+				}
+			}()
+			LimitConcurrency(max, time.Second)
+		}()
+	}
+}
+
+func TestLimitConcurrencyNoQueueTimeout(t *testing.T) {
+	h := LimitConcurrency(10, 0)(http.HandlerFunc(helloHandler))
+	for i := 0; i < 100; i++ {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Request with a free slot should be handled, got %v", w.Code)
+		}
+	}
+}
+
+func TestLimitConcurrencyCancelledWhileQueued(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{})
+	h := LimitConcurrency(1, time.Minute)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}))
+	go h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	<-started
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx))
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Errorf("Request cancelled while queued should be unavailable, got %v", w.Code)
+	}
+}
+
+func TestLimitConcurrencyReleasesOnPanic(t *testing.T) {
+	h := LimitConcurrency(1, 10*time.Millisecond)(http.HandlerFunc(handlerPanic))
+	for i := 0; i < 2; i++ {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Error("Handler should panic, not wait for a slot")
+				}
+			}()
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		}()
+	}
+}
+
+func TestServiceUnavailable(t *testing.T) {
+	for retryAfter, expected := range map[time.Duration]string{
+		90 * time.Second:        "90",
+		1500 * time.Millisecond: "2",
+		0:                       "1",
+	} {
+		w := httptest.NewRecorder()
+		ServiceUnavailable(retryAfter).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != expected {
+			t.Errorf("Retry after %v should give %q, got %v %q", retryAfter, expected, w.Code, w.Header().Get("Retry-After"))
+		}
+		if w.Header().Get("Cache-Control") != "no-store" {
+			t.Error("Response should not be cached")
+		}
+	}
+}
+
+func TestRateLimitPerRoute(t *testing.T) {
+	h := RateLimitPerRoute(rate.Every(time.Hour), 1, nil)(http.HandlerFunc(handlerTester))
+	serve := func(path, remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := serve("/login", "192.0.2.1:1234"); w.Code != http.StatusOK {
+		t.Fatalf("First request should be allowed, got %v", w.Code)
+	}
+	w := serve("/login", "192.0.2.1:5678")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("Second request to the same route should be limited, got %v", w.Code)
+	}
+	if w := serve("/search", "192.0.2.1:1234"); w.Code != http.StatusOK {
+		t.Errorf("Other route should have its own budget, got %v", w.Code)
+	}
+	if w := serve("/login", "192.0.2.2:1234"); w.Code != http.StatusOK {
+		t.Errorf("Other client should have its own budget, got %v", w.Code)
+	}
+}
+
+func TestRateLimitersEvictIdle(t *testing.T) {
+	l := &rateLimiters{limit: 1, burst: 1, entries: make(map[string]*rateLimiterEntry)}
+	now := time.Now()
+	l.get("old", now)
+	l.get("new", now.Add(2*rateLimiterIdleTimeout))
+	if _, ok := l.entries["old"]; ok || len(l.entries) != 1 {
+		t.Error("Idle limiters should be removed")
+	}
+}
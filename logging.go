@@ -0,0 +1,181 @@
+package adaptd
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var defaultLogger atomic.Pointer[log.Logger]
+
+// SetLogger sets the logger used by adapters that are not given one, like DisallowLongerPaths, HTTPSRedirect, and OnCheck.
+// If l is nil, the standard logger is used, which is the default.
+func SetLogger(l *log.Logger) {
+	defaultLogger.Store(l)
+}
+
+// packageLogger returns the logger set by SetLogger or the standard logger.
+func packageLogger() *log.Logger {
+	if l := defaultLogger.Load(); l != nil {
+		return l
+	}
+	return log.Default()
+}
+
+// NotifySlog adapter logs structured records when the request is beginning to be processed and when it is finished.
+// The records include the method, path, and remote address of the request.
+// The finishing record also includes the status code and the time taken, and is logged even if the handler panics.
+// The request's context is passed to the logger so handlers can add values like request IDs.
+func NotifySlog(logger *slog.Logger) Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			logger.LogAttrs(r.Context(), slog.LevelInfo, "Handling request",
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+				slog.String("remote_addr", r.RemoteAddr),
+			)
+			w, sr := captureStatus(w, r)
+			start := time.Now()
+			defer func() {
+				logger.LogAttrs(r.Context(), slog.LevelInfo, "Request handled",
+					slog.String("method", r.Method),
+					slog.String("path", r.URL.Path),
+					slog.String("remote_addr", r.RemoteAddr),
+					slog.Int("status", sr.status),
+					slog.Duration("duration", time.Since(start)),
+				)
+			}()
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// LogSlowerThan adapter logs requests that take longer than the threshold to handle,
+// including the status code of the response and the time taken. Faster requests are not logged.
+func LogSlowerThan(threshold time.Duration, logger *log.Logger) Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w, sr := captureStatus(w, r)
+			start := time.Now()
+			h.ServeHTTP(w, r)
+			if elapsed := time.Since(start); elapsed > threshold {
+				logger.Printf("Slow %v request at URL %v was handled with status %v in %v\n", r.Method, r.URL.Path, sr.status, elapsed)
+			}
+		})
+	}
+}
+
+// NotifySampled adapter logs when a fraction of the requests, from 0.0 for none to 1.0 for all, are finished.
+// Each request is chosen randomly. Requests given a 5xx response are always logged.
+// The log includes the status code of the response and the time taken.
+func NotifySampled(logger *log.Logger, rate float64) Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			sampled := rand.Float64() < rate
+			w, sr := captureStatus(w, r)
+			start := time.Now()
+			h.ServeHTTP(w, r)
+			if sampled || sr.status >= http.StatusInternalServerError {
+				logger.Printf("%v request at URL %v was handled with status %v in %v\n", r.Method, r.URL, sr.status, time.Since(start))
+			}
+		})
+	}
+}
+
+// Debug adapter logs "entering name" before calling the handler and "leaving name" after it returns, even if it panics.
+// Placing Debug adapters with different names between other adapters shows the order in which the adapters run.
+func Debug(logger *log.Logger, name string) Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			logger.Printf("entering %s\n", name)
+			defer logger.Printf("leaving %s\n", name)
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// AccessLogFormat is the format of the lines written by AccessLog.
+type AccessLogFormat int
+
+const (
+	// CommonLogFormat is the Common Log Format:
+	// host ident authuser [date] "request line" status bytes
+	CommonLogFormat AccessLogFormat = iota
+	// CombinedLogFormat is the Common Log Format followed by the quoted Referer and User-Agent headers.
+	CombinedLogFormat
+)
+
+// AccessLog adapter writes a line for each request to w in the Apache style format, for log analyzers.
+// The line includes the remote address, the time the request was received, the request line,
+// and the status code and size of the response. Quotes, backslashes, and control characters in fields are escaped.
+// Lines are written with a single call to w, and calls are never made at the same time.
+func AccessLog(w io.Writer, format AccessLogFormat) Adapter {
+	var mu sync.Mutex
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			rw, sr := captureStatus(rw, r)
+			start := time.Now()
+			h.ServeHTTP(rw, r)
+
+			line := accessLogLine(r, start, sr.status, sr.bytes, format)
+			mu.Lock()
+			defer mu.Unlock()
+			io.WriteString(w, line)
+		})
+	}
+}
+
+func accessLogLine(r *http.Request, start time.Time, status, bytes int, format AccessLogFormat) string {
+	host := stripPort(r.RemoteAddr)
+	if host == "" {
+		host = "-"
+	}
+	user := "-"
+	if u, _, ok := r.BasicAuth(); ok && u != "" {
+		user = escapeLogField(u)
+	}
+	size := "-"
+	if bytes > 0 {
+		size = strconv.Itoa(bytes)
+	}
+	line := fmt.Sprintf("%s - %s [%s] \"%s %s %s\" %d %s",
+		escapeLogField(host), user, start.Format("02/Jan/2006:15:04:05 -0700"),
+		escapeLogField(r.Method), escapeLogField(r.RequestURI), escapeLogField(r.Proto), status, size)
+	if format == CombinedLogFormat {
+		line += fmt.Sprintf(" \"%s\" \"%s\"", logHeader(r, "Referer"), logHeader(r, "User-Agent"))
+	}
+	return line + "\n"
+}
+
+// logHeader returns the escaped header value, or - if there is none.
+func logHeader(r *http.Request, name string) string {
+	if value := r.Header.Get(name); value != "" {
+		return escapeLogField(value)
+	}
+	return "-"
+}
+
+// escapeLogField escapes quotes, backslashes, and non-printable characters so the field cannot break the log line.
+func escapeLogField(field string) string {
+	var b strings.Builder
+	for i := 0; i < len(field); i++ {
+		switch c := field[i]; {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < 0x20 || c >= 0x7f:
+			fmt.Fprintf(&b, "\\x%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,194 @@
+package adaptd
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingHandler is a slog.Handler that keeps the records it handles.
+type recordingHandler struct {
+	mu      sync.Mutex
+	records []slog.Record
+}
+
+func (h *recordingHandler) Enabled(context.Context, slog.Level) bool { return true }
+
+func (h *recordingHandler) Handle(_ context.Context, r slog.Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.records = append(h.records, r)
+	return nil
+}
+
+func (h *recordingHandler) WithAttrs([]slog.Attr) slog.Handler { return h }
+
+func (h *recordingHandler) WithGroup(string) slog.Handler { return h }
+
+func recordAttrs(r slog.Record) map[string]slog.Value {
+	attrs := make(map[string]slog.Value)
+	r.Attrs(func(a slog.Attr) bool {
+		attrs[a.Key] = a.Value
+		return true
+	})
+	return attrs
+}
+
+func TestNotifySlog(t *testing.T) {
+	rh := &recordingHandler{}
+	h := NotifySlog(slog.New(rh))(http.HandlerFunc(http.NotFound))
+
+	req := httptest.NewRequest(http.MethodGet, "/missing", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	if len(rh.records) != 2 {
+		t.Fatalf("Expected 2 records, got %v", len(rh.records))
+	}
+	start := recordAttrs(rh.records[0])
+	if start["method"].String() != "GET" || start["path"].String() != "/missing" || start["remote_addr"].String() != "192.0.2.1:1234" {
+		t.Errorf("Unexpected attributes for starting record: %v", start)
+	}
+	end := recordAttrs(rh.records[1])
+	if end["method"].String() != "GET" || end["path"].String() != "/missing" || end["status"].Int64() != http.StatusNotFound {
+		t.Errorf("Unexpected attributes for finishing record: %v", end)
+	}
+	if _, ok := end["duration"]; !ok {
+		t.Error("Finishing record should include the duration")
+	}
+}
+
+func TestNotifySlogPanic(t *testing.T) {
+	rh := &recordingHandler{}
+	h := NotifySlog(slog.New(rh))(http.HandlerFunc(handlerPanic))
+	func() {
+		defer func() { recover() }()
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}()
+	if len(rh.records) != 2 || rh.records[1].Message != "Request handled" {
+		t.Errorf("Finishing record should be logged when the handler panics, got %v records", len(rh.records))
+	}
+}
+
+func TestLogSlowerThanFast(t *testing.T) {
+	var buf bytes.Buffer
+	h := LogSlowerThan(time.Second, log.New(&buf, "", 0))(http.HandlerFunc(handlerTester))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if buf.Len() != 0 {
+		t.Errorf("Fast request should not be logged, got %q", buf.String())
+	}
+}
+
+func TestLogSlowerThanSlow(t *testing.T) {
+	var buf bytes.Buffer
+	h := LogSlowerThan(10*time.Millisecond, log.New(&buf, "", 0))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/slow", nil))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 || !strings.HasPrefix(lines[0], "Slow POST request at URL /slow was handled with status 202 in ") {
+		t.Fatalf("Slow request should be logged once, got %q", buf.String())
+	}
+	if _, err := time.ParseDuration(strings.TrimPrefix(lines[0], "Slow POST request at URL /slow was handled with status 202 in ")); err != nil {
+		t.Errorf("Log line should end with the duration: %v", err)
+	}
+}
+
+func TestNotifySampled(t *testing.T) {
+	serverError := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	for rate, expected := range map[float64]int{0: 10, 1: 20} {
+		var buf bytes.Buffer
+		a := NotifySampled(log.New(&buf, "", 0), rate)
+		ok, failing := a(http.HandlerFunc(handlerTester)), a(serverError)
+		for i := 0; i < 10; i++ {
+			ok.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/ok", nil))
+			failing.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/error", nil))
+		}
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		if len(lines) != expected {
+			t.Errorf("Rate %v should log %v requests, got %v", rate, expected, len(lines))
+		}
+		if rate == 0 && strings.Contains(buf.String(), "/ok") {
+			t.Error("Rate 0 should only log server errors")
+		}
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	timestamp := regexp.MustCompile(`\[([^\]]+)\]`)
+	for format, golden := range map[AccessLogFormat]string{
+		CommonLogFormat:   `192.0.2.1 - alice [TIME] "GET /search?q=\"go\" HTTP/1.1" 200 12` + "\n",
+		CombinedLogFormat: `192.0.2.1 - alice [TIME] "GET /search?q=\"go\" HTTP/1.1" 200 12 "https://example.com/" "Test\\Agent"` + "\n",
+	} {
+		var buf bytes.Buffer
+		h := AccessLog(&buf, format)(http.HandlerFunc(helloHandler))
+		req := httptest.NewRequest(http.MethodGet, `/search?q="go"`, nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		req.SetBasicAuth("alice", "secret")
+		req.Header.Set("Referer", "https://example.com/")
+		req.Header.Set("User-Agent", `Test\Agent`)
+		h.ServeHTTP(httptest.NewRecorder(), req)
+
+		match := timestamp.FindStringSubmatch(buf.String())
+		if match == nil {
+			t.Fatalf("Line should have a timestamp, got %q", buf.String())
+		}
+		if _, err := time.Parse("02/Jan/2006:15:04:05 -0700", match[1]); err != nil {
+			t.Errorf("Timestamp is not in the expected format: %v", err)
+		}
+		if line := timestamp.ReplaceAllString(buf.String(), "[TIME]"); line != golden {
+			t.Errorf("Expected line %q, got %q", golden, line)
+		}
+	}
+}
+
+func TestAccessLogEmptyResponse(t *testing.T) {
+	var buf bytes.Buffer
+	h := AccessLog(&buf, CombinedLogFormat)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	req := httptest.NewRequest(http.MethodDelete, "/item", nil)
+	req.Header.Set("User-Agent", "bad\nagent")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if !strings.HasSuffix(buf.String(), `"DELETE /item HTTP/1.1" 204 - "-" "bad\x0aagent"`+"\n") {
+		t.Errorf("Unexpected line %q", buf.String())
+	}
+}
+
+func TestDebug(t *testing.T) {
+	var buf bytes.Buffer
+	logger := log.New(&buf, "", 0)
+	h := Adapt(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger.Println("handler")
+	}), Debug(logger, "outer"), Debug(logger, "inner"))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	expected := "entering outer\nentering inner\nhandler\nleaving inner\nleaving outer\n"
+	if buf.String() != expected {
+		t.Errorf("Expected the log %q, got %q", expected, buf.String())
+	}
+}
+
+func TestDebugPanic(t *testing.T) {
+	var buf bytes.Buffer
+	h := Debug(log.New(&buf, "", 0), "handler")(http.HandlerFunc(handlerPanic))
+	func() {
+		defer func() { recover() }()
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}()
+	if buf.String() != "entering handler\nleaving handler\n" {
+		t.Errorf("Leaving should be logged when the handler panics, got %q", buf.String())
+	}
+}
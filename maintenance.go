@@ -0,0 +1,57 @@
+package adaptd
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// MaintenanceMode serves a maintenance response for all requests while it is enabled.
+// It can be enabled and disabled at any time, including while requests are being handled.
+type MaintenanceMode struct {
+	enabled      atomic.Bool
+	handler      http.Handler
+	allowedPaths map[string]bool
+}
+
+// NewMaintenanceMode creates a disabled MaintenanceMode that serves the handler while enabled.
+// If handler is nil, a http.StatusServiceUnavailable error with a Retry-After header is given.
+// Requests to the allowed paths, e.g. health checks, are always passed through.
+func NewMaintenanceMode(handler http.Handler, allowedPaths ...string) *MaintenanceMode {
+	if handler == nil {
+		handler = ServiceUnavailable(2 * time.Minute)
+	}
+	m := &MaintenanceMode{handler: handler, allowedPaths: make(map[string]bool, len(allowedPaths))}
+	for _, p := range allowedPaths {
+		m.allowedPaths[p] = true
+	}
+	return m
+}
+
+// Enable starts serving the maintenance response.
+func (m *MaintenanceMode) Enable() {
+	m.enabled.Store(true)
+}
+
+// Disable stops serving the maintenance response.
+func (m *MaintenanceMode) Disable() {
+	m.enabled.Store(false)
+}
+
+// Enabled reports whether the maintenance response is being served.
+func (m *MaintenanceMode) Enabled() bool {
+	return m.enabled.Load()
+}
+
+// Adapter returns an Adapter that serves the maintenance response instead of calling the handler while enabled.
+func (m *MaintenanceMode) Adapter() Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if m.Enabled() && !m.allowedPaths[r.URL.Path] {
+				m.handler.ServeHTTP(w, r)
+				return
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
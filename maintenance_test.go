@@ -0,0 +1,63 @@
+package adaptd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestMaintenanceMode(t *testing.T) {
+	checkNumber = 0
+	m := NewMaintenanceMode(nil, "/healthz")
+	h := m.Adapter()(http.HandlerFunc(handlerTester))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusOK || checkNumber != 1 {
+		t.Error("Requests should be handled normally before maintenance is enabled")
+	}
+
+	m.Enable()
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" || checkNumber != 1 {
+		t.Error("Requests should get the maintenance response while enabled")
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if w.Code != http.StatusOK || checkNumber != 2 {
+		t.Error("Allowed paths should be handled normally while enabled")
+	}
+
+	m.Disable()
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusOK || checkNumber != 3 {
+		t.Error("Requests should be handled normally after maintenance is disabled")
+	}
+}
+
+func TestMaintenanceModeConcurrentToggle(t *testing.T) {
+	m := NewMaintenanceMode(http.HandlerFunc(http.NotFound))
+	h := m.Adapter()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			m.Enable()
+			m.Disable()
+		}()
+		go func() {
+			defer wg.Done()
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		}()
+	}
+	wg.Wait()
+	if m.Enabled() {
+		t.Error("Maintenance mode should be disabled")
+	}
+}
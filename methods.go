@@ -0,0 +1,124 @@
+package adaptd
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// overridableMethods are the methods a POST request can be overridden to by MethodOverride.
+var overridableMethods = map[string]bool{
+	http.MethodPut:    true,
+	http.MethodPatch:  true,
+	http.MethodDelete: true,
+}
+
+// MethodOverride adapter changes the method of POST requests to the method in the X-HTTP-Method-Override header
+// or, if the header is not present, the _method form field. This allows HTML forms to make PUT, PATCH, and DELETE requests.
+// Only PUT, PATCH, and DELETE are allowed as overrides; other values are ignored and requests with other methods are not changed.
+func MethodOverride() Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodPost {
+				override := r.Header.Get("X-HTTP-Method-Override")
+				if override == "" {
+					override = r.PostFormValue("_method")
+				}
+				if override = strings.ToUpper(strings.TrimSpace(override)); overridableMethods[override] {
+					r2 := new(http.Request)
+					*r2 = *r
+					r2.Method = override
+					r = r2
+				}
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// AllowOptions adapter answers OPTIONS requests with a http.StatusNoContent response
+// whose Allow header lists the given methods and OPTIONS. The handler is not called for OPTIONS requests.
+func AllowOptions(methods ...string) Adapter {
+	allowed := map[string]bool{http.MethodOptions: true}
+	for _, m := range methods {
+		allowed[m] = true
+	}
+	allowHeader := joinMethods(allowed)
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodOptions {
+				h.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Set("Allow", allowHeader)
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
+
+// AllowHead adapter answers HEAD requests by calling the handler as if it were a GET request
+// and discarding the body. The headers and status code of the GET response are kept,
+// and the Content-Length is set from the discarded body if the handler did not set it.
+func AllowHead() Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodHead {
+				h.ServeHTTP(w, r)
+				return
+			}
+			get := r.Clone(r.Context())
+			get.Method = http.MethodGet
+			hw := &headWriter{ResponseWriter: w, status: http.StatusOK}
+			h.ServeHTTP(hw, get)
+			if hw.hijacked {
+				return
+			}
+			if hw.bytes > 0 && w.Header().Get("Content-Length") == "" {
+				w.Header().Set("Content-Length", strconv.Itoa(hw.bytes))
+			}
+			w.WriteHeader(hw.status)
+		})
+	}
+}
+
+// headWriter discards the body of a response, counting its length.
+// The status is held so the Content-Length can be set after the handler is finished.
+type headWriter struct {
+	http.ResponseWriter
+	status      int
+	bytes       int
+	wroteHeader bool
+	hijacked    bool
+}
+
+func (hw *headWriter) WriteHeader(code int) {
+	if !hw.wroteHeader {
+		hw.status = code
+		hw.wroteHeader = true
+	}
+}
+
+func (hw *headWriter) Write(b []byte) (int, error) {
+	hw.wroteHeader = true
+	hw.bytes += len(b)
+	return len(b), nil
+}
+
+// Flush does nothing, as the header is held until the handler is finished and the body is discarded.
+func (hw *headWriter) Flush() {}
+
+// Hijack lets the handler take over the connection. Once it has, nothing more is written to the response.
+func (hw *headWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := hijack(hw.ResponseWriter)
+	if err == nil {
+		hw.hijacked = true
+	}
+	return conn, rw, err
+}
+
+// Unwrap returns the underlying http.ResponseWriter for http.ResponseController.
+func (hw *headWriter) Unwrap() http.ResponseWriter {
+	return hw.ResponseWriter
+}
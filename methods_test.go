@@ -0,0 +1,121 @@
+package adaptd
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func methodRecorder(method *string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*method = r.Method
+	})
+}
+
+func TestMethodOverrideForm(t *testing.T) {
+	var method string
+	h := MethodOverride()(methodRecorder(&method))
+
+	form := url.Values{"_method": {"DELETE"}}
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if method != http.MethodDelete {
+		t.Errorf("POST with _method=DELETE should be a DELETE, got %v", method)
+	}
+}
+
+func TestMethodOverrideHeader(t *testing.T) {
+	var method string
+	h := MethodOverride()(methodRecorder(&method))
+
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.Header.Set("X-HTTP-Method-Override", "patch")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if method != http.MethodPatch {
+		t.Errorf("POST with override header PATCH should be a PATCH, got %v", method)
+	}
+	if req.Method != http.MethodPost {
+		t.Errorf("Method of the caller's request should not be changed, got %v", req.Method)
+	}
+}
+
+func TestMethodOverrideIgnored(t *testing.T) {
+	var method string
+	h := MethodOverride()(methodRecorder(&method))
+
+	for _, override := range []string{"GET", "CONNECT", "UNKNOWN"} {
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		req.Header.Set("X-HTTP-Method-Override", override)
+		h.ServeHTTP(httptest.NewRecorder(), req)
+		if method != http.MethodPost {
+			t.Errorf("Override %v should be ignored, got %v", override, method)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-HTTP-Method-Override", "DELETE")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if method != http.MethodGet {
+		t.Errorf("GET request should never be overridden, got %v", method)
+	}
+}
+
+func TestAllowHead(t *testing.T) {
+	getOnly := RequestMethod(http.MethodGet)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("X-Custom", "value")
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("hello, world"))
+	}))
+	ts := httptest.NewServer(AllowHead()(getOnly))
+	defer ts.Close()
+	client := ts.Client()
+
+	get, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	get.Body.Close()
+	head, err := client.Head(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(head.Body)
+	head.Body.Close()
+
+	if head.StatusCode != get.StatusCode || len(body) != 0 {
+		t.Errorf("HEAD response should have status %v and no body, got %v and %q", get.StatusCode, head.StatusCode, body)
+	}
+	for _, name := range []string{"Content-Type", "Content-Length", "X-Custom"} {
+		if head.Header.Get(name) != get.Header.Get(name) {
+			t.Errorf("HEAD header %v should be %q, got %q", name, get.Header.Get(name), head.Header.Get(name))
+		}
+	}
+	if head.ContentLength != int64(len("hello, world")) {
+		t.Errorf("HEAD response should have the length of the GET body, got %v", head.ContentLength)
+	}
+}
+
+func TestAllowOptions(t *testing.T) {
+	checkNumber = 0
+	h := AllowOptions(http.MethodPost, http.MethodGet)(http.HandlerFunc(handlerTester))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodOptions, "/", nil))
+	if w.Code != http.StatusNoContent || checkNumber != 0 {
+		t.Error("OPTIONS request should be answered without calling the handler")
+	}
+	if allow := w.Header().Get("Allow"); allow != "GET, OPTIONS, POST" {
+		t.Errorf("Unexpected Allow header %q", allow)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusOK || checkNumber != 1 || w.Header().Get("Allow") != "" {
+		t.Error("GET request should be passed to the handler")
+	}
+}
@@ -0,0 +1,108 @@
+package adaptd
+
+import (
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Negotiate adapter chooses the handler for the media type that best matches the request's Accept header,
+// taking q-values and wildcards into account, and sets the Content-Type to that media type.
+// The handler provided to the Adapter is used for defaultType unless a handler for it is in the map,
+// e.g. Negotiate(map[string]http.Handler{"application/json": jsonHandler}, "text/html")(htmlHandler)
+// Requests without an Accept header get the default type, as do requests accepting */* when no other
+// media type is preferred. Requests whose Accept header matches none of the media types, or excludes them with q=0,
+// are given a http.StatusNotAcceptable error, as are requests without an Accept header if defaultType is empty.
+func Negotiate(offers map[string]http.Handler, defaultType string) Adapter {
+	return func(h http.Handler) http.Handler {
+		handlers := make(map[string]http.Handler, len(offers)+1)
+		if defaultType != "" && h != nil {
+			handlers[defaultType] = h
+		}
+		for mediaType, handler := range offers {
+			handlers[mediaType] = handler
+		}
+		types := make([]string, 0, len(handlers))
+		for mediaType := range handlers {
+			types = append(types, mediaType)
+		}
+		sort.Strings(types)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			addVary(w.Header(), "Accept")
+			chosen := defaultType
+			if ranges := parseAccept(r.Header.Get("Accept")); len(ranges) > 0 {
+				chosen = bestOffer(ranges, types, defaultType)
+			}
+			handler, ok := handlers[chosen]
+			if !ok {
+				http.Error(w, http.StatusText(http.StatusNotAcceptable), http.StatusNotAcceptable)
+				return
+			}
+			w.Header().Set("Content-Type", chosen)
+			handler.ServeHTTP(w, r)
+		})
+	}
+}
+
+// acceptRange is a media range from an Accept header.
+type acceptRange struct {
+	mainType, subType string
+	q                 float64
+}
+
+func parseAccept(accept string) []acceptRange {
+	var ranges []acceptRange
+	for _, value := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(value))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		mainType, subType, _ := strings.Cut(mediaType, "/")
+		ranges = append(ranges, acceptRange{mainType: mainType, subType: subType, q: q})
+	}
+	return ranges
+}
+
+// match returns how specifically the range matches the media type: 3 for an exact match,
+// 2 for a type/* match, 1 for a */* match, and 0 if it does not match.
+func (a acceptRange) match(mediaType string) int {
+	mainType, subType, _ := strings.Cut(strings.ToLower(mediaType), "/")
+	switch {
+	case a.mainType == mainType && a.subType == subType:
+		return 3
+	case a.mainType == mainType && a.subType == "*":
+		return 2
+	case a.mainType == "*" && a.subType == "*":
+		return 1
+	}
+	return 0
+}
+
+// bestOffer returns the media type with the highest q-value from the most specific matching range.
+// Ties are broken by specificity and then by preferring the default type.
+func bestOffer(ranges []acceptRange, types []string, defaultType string) string {
+	best, bestQ, bestSpecificity := "", 0.0, 0
+	for _, t := range types {
+		q, specificity := 0.0, 0
+		for _, a := range ranges {
+			if s := a.match(t); s > specificity {
+				q, specificity = a.q, s
+			}
+		}
+		if q <= 0 {
+			continue
+		}
+		if q > bestQ || (q == bestQ && (specificity > bestSpecificity || (specificity == bestSpecificity && t == defaultType))) {
+			best, bestQ, bestSpecificity = t, q, specificity
+		}
+	}
+	return best
+}
@@ -0,0 +1,84 @@
+package adaptd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func negotiateHandler(name string, called *string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*called = name
+	})
+}
+
+func TestNegotiate(t *testing.T) {
+	var called string
+	offers := map[string]http.Handler{
+		"application/json": negotiateHandler("json", &called),
+		"text/html":        negotiateHandler("html", &called),
+	}
+	h := Negotiate(offers, "text/html")(nil)
+
+	for accept, expected := range map[string]string{
+		"application/json":                     "json",
+		"text/html":                            "html",
+		"*/*":                                  "html",
+		"application/*":                        "json",
+		"text/html;q=0.5, application/json":    "json",
+		"application/json;q=0.1, text/*;q=0.9": "html",
+		"":                                     "html",
+	} {
+		called = ""
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if called != expected || w.Header().Get("Content-Type") != map[string]string{"json": "application/json", "html": "text/html"}[expected] {
+			t.Errorf("Accept %q should be handled by %v, got %q", accept, expected, called)
+		}
+	}
+}
+
+func TestNegotiateNotAcceptable(t *testing.T) {
+	var called string
+	h := Negotiate(map[string]http.Handler{"application/json": negotiateHandler("json", &called)}, "")(nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept", "text/html, application/json;q=0")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusNotAcceptable || called != "" {
+		t.Error("Unsatisfiable Accept should not be acceptable")
+	}
+}
+
+func TestNegotiateDefaultNotAcceptable(t *testing.T) {
+	var called string
+	h := Negotiate(map[string]http.Handler{"application/json": negotiateHandler("json", &called)}, "text/html")(negotiateHandler("html", &called))
+
+	for _, accept := range []string{"application/xml", "text/html;q=0, application/json;q=0", "*/*;q=0"} {
+		called = ""
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != http.StatusNotAcceptable || called != "" {
+			t.Errorf("Accept %q excluding every media type should not be acceptable, got %v handled by %q", accept, w.Code, called)
+		}
+	}
+}
+
+func TestNegotiateAdapterHandlerIsDefault(t *testing.T) {
+	var called string
+	h := Negotiate(map[string]http.Handler{"application/json": negotiateHandler("json", &called)}, "text/html")(negotiateHandler("html", &called))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept", "text/html")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if called != "html" {
+		t.Errorf("Handler provided to the Adapter should handle the default type, got %q", called)
+	}
+}
@@ -0,0 +1,114 @@
+package adaptd
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// StripPrefix adapter removes the prefix from the request's URL path before calling the handler,
+// like http.StripPrefix. Requests whose path does not start with the prefix are given a http.StatusNotFound error.
+func StripPrefix(prefix string) Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p := strings.TrimPrefix(r.URL.Path, prefix)
+			rp := strings.TrimPrefix(r.URL.RawPath, prefix)
+			if len(p) == len(r.URL.Path) || (r.URL.RawPath != "" && len(rp) == len(r.URL.RawPath)) {
+				http.NotFound(w, r)
+				return
+			}
+			h.ServeHTTP(w, withPath(r, p, rp))
+		})
+	}
+}
+
+// RewritePath adapter replaces the prefix from of the request's URL path with to before calling the handler,
+// e.g. RewritePath("/v1", "") rewrites /v1/users to /users. The prefix only matches whole path segments.
+// Unlike a redirect, the client does not see the rewrite. The query and method are unchanged
+// and other requests are passed to the handler as is.
+func RewritePath(from, to string) Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !hasPathPrefix(r.URL.Path, from) {
+				h.ServeHTTP(w, r)
+				return
+			}
+			rp := ""
+			if r.URL.RawPath != "" && hasPathPrefix(r.URL.RawPath, from) {
+				rp = rootedPath(to + strings.TrimPrefix(r.URL.RawPath, from))
+			}
+			h.ServeHTTP(w, withPath(r, rootedPath(to+strings.TrimPrefix(r.URL.Path, from)), rp))
+		})
+	}
+}
+
+// RewritePathRegexp adapter replaces the request's URL path with the replacement if it matches re before calling the handler.
+// The replacement can refer to capture groups, e.g. RewritePathRegexp(regexp.MustCompile(`^/u/(\w+)$`), "/users/$1")
+// rewrites /u/gopher to /users/gopher.
+// The decoded path is matched. Unlike a redirect, the client does not see the rewrite. The query and method are unchanged
+// and other requests are passed to the handler as is.
+func RewritePathRegexp(re *regexp.Regexp, replacement string) Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !re.MatchString(r.URL.Path) {
+				h.ServeHTTP(w, r)
+				return
+			}
+			h.ServeHTTP(w, withPath(r, rootedPath(re.ReplaceAllString(r.URL.Path, replacement)), ""))
+		})
+	}
+}
+
+// BlockPathTraversal adapter gives a http.StatusBadRequest error to requests whose decoded path has a .. segment
+// or a null byte, before they reach handlers like file servers. Paths are decoded twice, so encoded sequences
+// like %2e%2e and %252e%252e are caught, and backslashes are treated as separators.
+func BlockPathTraversal() Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if isTraversal(r.URL.Path) {
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				return
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// isTraversal reports whether the decoded path, or the path decoded again, has a .. segment or a null byte.
+func isTraversal(path string) bool {
+	paths := []string{path}
+	if decoded, err := url.PathUnescape(path); err == nil && decoded != path {
+		paths = append(paths, decoded)
+	}
+	for _, p := range paths {
+		if strings.ContainsRune(p, 0) {
+			return true
+		}
+		for _, segment := range strings.FieldsFunc(p, func(c rune) bool { return c == '/' || c == '\\' }) {
+			if segment == ".." {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// withPath returns a shallow copy of the request with the given URL path and raw path.
+func withPath(r *http.Request, path, rawPath string) *http.Request {
+	r2 := new(http.Request)
+	*r2 = *r
+	r2.URL = new(url.URL)
+	*r2.URL = *r.URL
+	r2.URL.Path = path
+	r2.URL.RawPath = rawPath
+	return r2
+}
+
+// rootedPath makes sure the path starts with a slash.
+func rootedPath(path string) string {
+	if !strings.HasPrefix(path, "/") {
+		return "/" + path
+	}
+	return path
+}
@@ -0,0 +1,107 @@
+package adaptd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+)
+
+func pathRecorder(path, rawPath *string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*path, *rawPath = r.URL.Path, r.URL.RawPath
+	})
+}
+
+func TestStripPrefix(t *testing.T) {
+	var path, rawPath string
+	h := StripPrefix("/app")(pathRecorder(&path, &rawPath))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/app/users?id=1", nil))
+	if w.Code != http.StatusOK || path != "/users" || rawPath != "" {
+		t.Errorf("Prefix should be stripped, got %q", path)
+	}
+}
+
+func TestStripPrefixNotFound(t *testing.T) {
+	path, rawPath := "unchanged", ""
+	h := StripPrefix("/app")(pathRecorder(&path, &rawPath))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/other/users", nil))
+	if w.Code != http.StatusNotFound || path != "unchanged" {
+		t.Error("Path without the prefix should not be found")
+	}
+}
+
+func TestStripPrefixEscapedPath(t *testing.T) {
+	var path, rawPath string
+	h := StripPrefix("/app")(pathRecorder(&path, &rawPath))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/app/files/a%2Fb", nil))
+	if w.Code != http.StatusOK || path != "/files/a/b" || rawPath != "/files/a%2Fb" {
+		t.Errorf("Prefix should be stripped from both paths, got %q and %q", path, rawPath)
+	}
+}
+
+func TestRewritePath(t *testing.T) {
+	var path, rawPath, query string
+	h := RewritePath("/v1", "")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, rawPath, query = r.URL.Path, r.URL.RawPath, r.URL.RawQuery
+	}))
+
+	for _, c := range []struct{ target, path, query string }{
+		{"/v1/users?page=2", "/users", "page=2"},
+		{"/v1", "/", ""},
+		{"/v10/users", "/v10/users", ""},
+		{"/users", "/users", ""},
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, c.target, nil))
+		if path != c.path || query != c.query {
+			t.Errorf("Request to %v should be rewritten to %v with query %q, got %v with %q", c.target, c.path, c.query, path, query)
+		}
+	}
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/v1/a%2Fb?page=2", nil))
+	if path != "/a/b" || rawPath != "/a%2Fb" || query != "page=2" {
+		t.Errorf("Rewrite should keep the encoding and query, got %q %q %q", path, rawPath, query)
+	}
+}
+
+func TestRewritePathRegexp(t *testing.T) {
+	var path, rawPath string
+	h := RewritePathRegexp(regexp.MustCompile(`^/u/(\w+)$`), "/users/$1")(pathRecorder(&path, &rawPath))
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/u/gopher", nil))
+	if path != "/users/gopher" {
+		t.Errorf("Path should be rewritten with the capture group, got %v", path)
+	}
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/u/gopher/posts", nil))
+	if path != "/u/gopher/posts" {
+		t.Errorf("Unmatched path should pass through, got %v", path)
+	}
+}
+
+func TestBlockPathTraversal(t *testing.T) {
+	h := BlockPathTraversal()(http.HandlerFunc(handlerTester))
+	for target, expected := range map[string]int{
+		"/../etc/passwd":          http.StatusBadRequest,
+		"/%2e%2e/secret":          http.StatusBadRequest,
+		"/static/%2E%2E%2fsecret": http.StatusBadRequest,
+		"/%252e%252e/secret":      http.StatusBadRequest,
+		"/static/..%5csecret":     http.StatusBadRequest,
+		"/static/file%00.png":     http.StatusBadRequest,
+		"/static/app..min.js":     http.StatusOK,
+		"/docs/v1.2/":             http.StatusOK,
+	} {
+		checkNumber = 0
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		if w.Code != expected || (expected == http.StatusBadRequest) == (checkNumber == 1) {
+			t.Errorf("Request to %v should give %v, got %v", target, expected, w.Code)
+		}
+	}
+}
@@ -1,17 +1,61 @@
 package adaptd
 
 import (
+	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 )
 
+// MetricsOptions configure the prometheus adapters.
+type MetricsOptions struct {
+	// Registerer is where the metrics are registered. The default is prometheus.DefaultRegisterer.
+	// If the metric is already registered, the existing one is used.
+	Registerer prometheus.Registerer
+	// Endpoint returns the value of the endpoint label for the request.
+	// It can be used to map paths like /users/123 to a route template like /users/{id}
+	// so that every ID does not create a new time series. The default is the pattern matched by a http.ServeMux
+	// wrapped by the adapter, e.g. /users/{id}, or the URL path of the request if no pattern was matched.
+	Endpoint func(*http.Request) string
+	// StatusClasses replaces the value of the code label with the class of the status code, e.g. 2xx or 4xx,
+	// to reduce the number of time series. The default is the exact status code.
+	StatusClasses bool
+}
+
+func (o MetricsOptions) endpoint(r *http.Request) string {
+	if o.Endpoint != nil {
+		return o.Endpoint(r)
+	}
+	if route := routePattern(r); route != "" {
+		return route
+	}
+	return r.URL.Path
+}
+
+func (o MetricsOptions) code(status int) string {
+	if o.StatusClasses {
+		return strconv.Itoa(status/100) + "xx"
+	}
+	return strconv.Itoa(status)
+}
+
 // CountHTTPResponses calls the handler and records the response as a prometheus counter
 // with labels endpoint, code, and method.
 // This should be applied once for an entire web server.
 func CountHTTPResponses() Adapter {
+	a, err := CountHTTPResponsesWithOptions(MetricsOptions{})
+	if err != nil {
+		panic(err)
+	}
+	return a
+}
+
+// CountHTTPResponsesWithOptions is like CountHTTPResponses, but the metric is configured with the options.
+// An error is returned if the metric cannot be registered.
+func CountHTTPResponsesWithOptions(opts MetricsOptions) (Adapter, error) {
 	httpRequests := prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "http_requests_total",
@@ -19,20 +63,37 @@ func CountHTTPResponses() Adapter {
 		},
 		[]string{"endpoint", "code", "method"},
 	)
-	prometheus.MustRegister(httpRequests)
+	c, err := registerCollector(opts.Registerer, httpRequests)
+	if err != nil {
+		return nil, err
+	}
+	httpRequests, ok := c.(*prometheus.CounterVec)
+	if !ok {
+		return nil, fmt.Errorf("adaptd: http_requests_total is registered as a %T", c)
+	}
 	return func(h http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			sr := &statusRecorder{w, 200}
-			h.ServeHTTP(sr, r)
-			httpRequests.WithLabelValues(r.URL.Path, strconv.Itoa(sr.status), r.Method).Inc()
+			w, sr := captureStatus(w, r)
+			h.ServeHTTP(w, r)
+			httpRequests.WithLabelValues(opts.endpoint(r), opts.code(sr.status), r.Method).Inc()
 		})
-	}
+	}, nil
 }
 
 // TrackHTTPResponseTimes calls the handler and records the response time
 // as a prometheus summary with labels endpoint, code, and method.
 // This should be applied once for an entire web server.
 func TrackHTTPResponseTimes() Adapter {
+	a, err := TrackHTTPResponseTimesWithOptions(MetricsOptions{})
+	if err != nil {
+		panic(err)
+	}
+	return a
+}
+
+// TrackHTTPResponseTimesWithOptions is like TrackHTTPResponseTimes, but the metric is configured with the options.
+// An error is returned if the metric cannot be registered.
+func TrackHTTPResponseTimesWithOptions(opts MetricsOptions) (Adapter, error) {
 	httpRequests := prometheus.NewSummaryVec(
 		prometheus.SummaryOpts{
 			Name: "http_requests_seconds",
@@ -40,15 +101,260 @@ func TrackHTTPResponseTimes() Adapter {
 		},
 		[]string{"endpoint", "code", "method"},
 	)
-	prometheus.MustRegister(httpRequests)
+	c, err := registerCollector(opts.Registerer, httpRequests)
+	if err != nil {
+		return nil, err
+	}
+	httpRequests, ok := c.(*prometheus.SummaryVec)
+	if !ok {
+		return nil, fmt.Errorf("adaptd: http_requests_seconds is registered as a %T", c)
+	}
 	return func(h http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			sr := &statusRecorder{w, 200}
+			w, sr := captureStatus(w, r)
 			start := time.Now().Unix()
-			h.ServeHTTP(sr, r)
-			httpRequests.WithLabelValues(r.URL.Path, strconv.Itoa(sr.status), r.Method).Observe(
+			h.ServeHTTP(w, r)
+			httpRequests.WithLabelValues(opts.endpoint(r), opts.code(sr.status), r.Method).Observe(
 				float64(time.Now().Unix() - start),
 			)
 		})
+	}, nil
+}
+
+// TrackHTTPResponseHistogram calls the handler and records the response time as a prometheus histogram
+// with the given buckets and labels endpoint, code, and method.
+// Unlike the summary of TrackHTTPResponseTimes, histograms can be aggregated across instances.
+// This should be applied once for an entire web server.
+func TrackHTTPResponseHistogram(buckets []float64) Adapter {
+	a, err := TrackHTTPResponseHistogramWithOptions(buckets, MetricsOptions{})
+	if err != nil {
+		panic(err)
+	}
+	return a
+}
+
+// TrackHTTPResponseHistogramWithOptions is like TrackHTTPResponseHistogram, but the metric is configured with the options.
+// An error is returned if the metric cannot be registered.
+func TrackHTTPResponseHistogramWithOptions(buckets []float64, opts MetricsOptions) (Adapter, error) {
+	httpRequests, err := registerHistogramVec(opts.Registerer, prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "The response times to HTTP requests, partitioned by endpoint, status code, and HTTP method.",
+		Buckets: buckets,
+	}, []string{"endpoint", "code", "method"})
+	if err != nil {
+		return nil, err
+	}
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w, sr := captureStatus(w, r)
+			start := time.Now()
+			h.ServeHTTP(w, r)
+			httpRequests.WithLabelValues(opts.endpoint(r), opts.code(sr.status), r.Method).Observe(time.Since(start).Seconds())
+		})
+	}, nil
+}
+
+// TrackTransferSizes calls the handler and records the size of the request body and the response body
+// as prometheus histograms with labels endpoint and method.
+// This should be applied once for an entire web server.
+func TrackTransferSizes() Adapter {
+	a, err := TrackTransferSizesWithOptions(MetricsOptions{})
+	if err != nil {
+		panic(err)
+	}
+	return a
+}
+
+// TrackTransferSizesWithOptions is like TrackTransferSizes, but the metrics are configured with the options.
+// An error is returned if the metrics cannot be registered.
+func TrackTransferSizesWithOptions(opts MetricsOptions) (Adapter, error) {
+	requestSizes, err := registerHistogramVec(opts.Registerer, prometheus.HistogramOpts{
+		Name:    "http_request_size_bytes",
+		Help:    "The sizes of HTTP request bodies, partitioned by endpoint and HTTP method.",
+		Buckets: prometheus.ExponentialBuckets(100, 10, 6),
+	}, []string{"endpoint", "method"})
+	if err != nil {
+		return nil, err
+	}
+	responseSizes, err := registerHistogramVec(opts.Registerer, prometheus.HistogramOpts{
+		Name:    "http_response_size_bytes",
+		Help:    "The sizes of HTTP response bodies, partitioned by endpoint and HTTP method.",
+		Buckets: prometheus.ExponentialBuckets(100, 10, 6),
+	}, []string{"endpoint", "method"})
+	if err != nil {
+		return nil, err
+	}
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w, sr := captureStatus(w, r)
+			var body *countingReader
+			if r.Body != nil {
+				body = &countingReader{ReadCloser: r.Body}
+				r2 := new(http.Request)
+				*r2 = *r
+				r2.Body = body
+				r = r2
+			}
+			h.ServeHTTP(w, r)
+			requestBytes := 0
+			if body != nil {
+				requestBytes = body.bytes
+			}
+			endpoint := opts.endpoint(r)
+			requestSizes.WithLabelValues(endpoint, r.Method).Observe(float64(requestBytes))
+			responseSizes.WithLabelValues(endpoint, r.Method).Observe(float64(sr.bytes))
+		})
+	}, nil
+}
+
+// CountSLOViolations calls the handler and counts the requests that took longer than the threshold
+// as a prometheus counter with labels endpoint and method.
+// Together with http_requests_total, this gives the fraction of requests that breached the latency objective.
+// This should be applied once for an entire web server.
+func CountSLOViolations(threshold time.Duration) Adapter {
+	a, err := CountSLOViolationsWithOptions(threshold, MetricsOptions{})
+	if err != nil {
+		panic(err)
+	}
+	return a
+}
+
+// CountSLOViolationsWithOptions is like CountSLOViolations, but the metric is configured with the options.
+// An error is returned if the metric cannot be registered.
+func CountSLOViolationsWithOptions(threshold time.Duration, opts MetricsOptions) (Adapter, error) {
+	violations, err := registerCounterVec(opts.Registerer, prometheus.CounterOpts{
+		Name: "http_request_slo_violations_total",
+		Help: "How many HTTP requests took longer than the latency objective, partitioned by endpoint and HTTP method.",
+	}, []string{"endpoint", "method"})
+	if err != nil {
+		return nil, err
+	}
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			h.ServeHTTP(w, r)
+			if time.Since(start) > threshold {
+				violations.WithLabelValues(opts.endpoint(r), r.Method).Inc()
+			}
+		})
+	}, nil
+}
+
+// CountPanics calls the handler, recovering from panics, and counts the panics as a prometheus counter
+// with labels endpoint and method. A recovered panic is handled as Recover does: it is logged with its stack trace
+// and a http.StatusInternalServerError error is given, or the response is aborted if it was already started.
+// Panics with http.ErrAbortHandler are not recovered.
+// This should be applied once for an entire web server.
+func CountPanics() Adapter {
+	a, err := CountPanicsWithOptions(MetricsOptions{})
+	if err != nil {
+		panic(err)
+	}
+	return a
+}
+
+// CountPanicsWithOptions is like CountPanics, but the metric is configured with the options.
+// An error is returned if the metric cannot be registered.
+func CountPanicsWithOptions(opts MetricsOptions) (Adapter, error) {
+	panics, err := registerCounterVec(opts.Registerer, prometheus.CounterOpts{
+		Name: "http_panics_total",
+		Help: "How many panics were recovered while handling HTTP requests, partitioned by endpoint and HTTP method.",
+	}, []string{"endpoint", "method"})
+	if err != nil {
+		return nil, err
+	}
+	return recoverPanics(packageLogger, nil, func(r *http.Request) {
+		panics.WithLabelValues(opts.endpoint(r), r.Method).Inc()
+	}), nil
+}
+
+// CountByClientClass calls the handler and counts the requests as a prometheus counter with labels endpoint
+// and class, the class of client given by ClientClass, so bot and browser traffic can be compared
+// without a time series for every User-Agent. This should be applied once for an entire web server.
+func CountByClientClass() Adapter {
+	a, err := CountByClientClassWithOptions(nil, MetricsOptions{})
+	if err != nil {
+		panic(err)
+	}
+	return a
+}
+
+// CountByClientClassWithOptions is like CountByClientClass, but the User-Agent of the request is classified
+// with classify and the metric is configured with the options. If classify is nil, ClientClass is used.
+// The classes should be a small set to keep the number of time series low.
+// An error is returned if the metric cannot be registered.
+func CountByClientClassWithOptions(classify func(userAgent string) string, opts MetricsOptions) (Adapter, error) {
+	if classify == nil {
+		classify = ClientClass
+	}
+	requests, err := registerCounterVec(opts.Registerer, prometheus.CounterOpts{
+		Name: "http_requests_by_client_class_total",
+		Help: "How many HTTP requests processed, partitioned by endpoint and class of client.",
+	}, []string{"endpoint", "class"})
+	if err != nil {
+		return nil, err
+	}
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h.ServeHTTP(w, r)
+			requests.WithLabelValues(opts.endpoint(r), classify(r.UserAgent())).Inc()
+		})
+	}, nil
+}
+
+// ClientClass classifies the User-Agent with simple heuristics as "bot" for crawlers and other bots,
+// "curl" for command line clients like curl and Wget, "browser" for web browsers, or "other".
+// User-Agents are easily faked, so the class should only be used for analytics.
+func ClientClass(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+	switch {
+	case ua == "":
+		return "other"
+	case strings.Contains(ua, "bot") || strings.Contains(ua, "crawl") || strings.Contains(ua, "spider") ||
+		strings.Contains(ua, "slurp") || strings.Contains(ua, "facebookexternalhit") || strings.Contains(ua, "headless"):
+		return "bot"
+	case strings.HasPrefix(ua, "curl/") || strings.HasPrefix(ua, "wget/") || strings.HasPrefix(ua, "httpie/"):
+		return "curl"
+	case strings.HasPrefix(ua, "mozilla/") || strings.HasPrefix(ua, "opera/"):
+		return "browser"
+	}
+	return "other"
+}
+
+func registerCounterVec(reg prometheus.Registerer, opts prometheus.CounterOpts, labels []string) (*prometheus.CounterVec, error) {
+	c, err := registerCollector(reg, prometheus.NewCounterVec(opts, labels))
+	if err != nil {
+		return nil, err
+	}
+	counter, ok := c.(*prometheus.CounterVec)
+	if !ok {
+		return nil, fmt.Errorf("adaptd: %v is registered as a %T", opts.Name, c)
+	}
+	return counter, nil
+}
+
+func registerHistogramVec(reg prometheus.Registerer, opts prometheus.HistogramOpts, labels []string) (*prometheus.HistogramVec, error) {
+	c, err := registerCollector(reg, prometheus.NewHistogramVec(opts, labels))
+	if err != nil {
+		return nil, err
+	}
+	h, ok := c.(*prometheus.HistogramVec)
+	if !ok {
+		return nil, fmt.Errorf("adaptd: %v is registered as a %T", opts.Name, c)
+	}
+	return h, nil
+}
+
+// registerCollector registers the collector, returning the existing collector if one is already registered.
+func registerCollector(reg prometheus.Registerer, c prometheus.Collector) (prometheus.Collector, error) {
+	if reg == nil {
+		reg = prometheus.DefaultRegisterer
+	}
+	if err := reg.Register(c); err != nil {
+		if are, ok := err.(prometheus.AlreadyRegisteredError); ok {
+			return are.ExistingCollector, nil
+		}
+		return nil, err
 	}
+	return c, nil
 }
@@ -0,0 +1,341 @@
+package adaptd
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCountHTTPResponsesTwice(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	first, err := CountHTTPResponsesWithOptions(MetricsOptions{Registerer: reg})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := CountHTTPResponsesWithOptions(MetricsOptions{Registerer: reg})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first(http.HandlerFunc(handlerTester)).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	second(http.HandlerFunc(handlerTester)).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if n, err := testutil.GatherAndCount(reg, "http_requests_total"); err != nil || n != 1 {
+		t.Errorf("Expected one series, got %v", n)
+	}
+	if v := gatheredValue(t, reg, "http_requests_total"); v != 2 {
+		t.Errorf("Both adapters should increment the same counter, got %v", v)
+	}
+}
+
+func TestTrackHTTPResponseTimesTwice(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	for i := 0; i < 2; i++ {
+		if _, err := TrackHTTPResponseTimesWithOptions(MetricsOptions{Registerer: reg}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCountHTTPResponsesConflictingMetric(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(prometheus.NewCounter(prometheus.CounterOpts{Name: "http_requests_total", Help: "Conflicting metric"}))
+	if _, err := CountHTTPResponsesWithOptions(MetricsOptions{Registerer: reg}); err == nil {
+		t.Error("Registering a conflicting metric should give an error")
+	}
+}
+
+func TestTrackHTTPResponseHistogram(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	a, err := TrackHTTPResponseHistogramWithOptions([]float64{0.005, 0.5, 5}, MetricsOptions{Registerer: reg})
+	if err != nil {
+		t.Fatal(err)
+	}
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(10 * time.Millisecond)
+	})
+	a(slow).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/slow", nil))
+
+	families, err := reg.Gather()
+	if err != nil || len(families) != 1 || len(families[0].GetMetric()) != 1 {
+		t.Fatal("Expected a single histogram series")
+	}
+	expected := map[float64]uint64{0.005: 0, 0.5: 1, 5: 1}
+	for _, b := range families[0].GetMetric()[0].GetHistogram().GetBucket() {
+		if b.GetCumulativeCount() != expected[b.GetUpperBound()] {
+			t.Errorf("Bucket %v should have count %v, got %v", b.GetUpperBound(), expected[b.GetUpperBound()], b.GetCumulativeCount())
+		}
+	}
+}
+
+func TestCountHTTPResponsesEndpoint(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	userIDs := regexp.MustCompile(`^/users/\d+$`)
+	a, err := CountHTTPResponsesWithOptions(MetricsOptions{
+		Registerer: reg,
+		Endpoint: func(r *http.Request) string {
+			return userIDs.ReplaceAllString(r.URL.Path, "/users/{id}")
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := a(http.HandlerFunc(handlerTester))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users/123", nil))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users/456", nil))
+
+	expected := `
+# HELP http_requests_total How many HTTP requests processed, partitioned by endpoint, status code, and HTTP method.
+# TYPE http_requests_total counter
+http_requests_total{code="200",endpoint="/users/{id}",method="GET"} 2
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "http_requests_total"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCountHTTPResponsesPattern(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	a, err := CountHTTPResponsesWithOptions(MetricsOptions{Registerer: reg})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/users/123", nil)
+	req.Pattern = "GET /users/{id}"
+	a(http.HandlerFunc(handlerTester)).ServeHTTP(httptest.NewRecorder(), req)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /users/{id}", handlerTester)
+	h := a(mux)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users/456", nil))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/about", nil))
+
+	expected := `
+# HELP http_requests_total How many HTTP requests processed, partitioned by endpoint, status code, and HTTP method.
+# TYPE http_requests_total counter
+http_requests_total{code="200",endpoint="/users/{id}",method="GET"} 2
+http_requests_total{code="404",endpoint="/about",method="GET"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "http_requests_total"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTrackTransferSizes(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	a, err := TrackTransferSizesWithOptions(MetricsOptions{Registerer: reg})
+	if err != nil {
+		t.Fatal(err)
+	}
+	echo := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+		w.Write([]byte("!"))
+	})
+	req := httptest.NewRequest(http.MethodPost, "/echo", strings.NewReader("hello, world"))
+	body := req.Body
+	a(echo).ServeHTTP(httptest.NewRecorder(), req)
+	if req.Body != body {
+		t.Error("Body of the caller's request should not be replaced")
+	}
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]float64{"http_request_size_bytes": 12, "http_response_size_bytes": 13}
+	for _, f := range families {
+		if len(f.GetMetric()) != 1 {
+			t.Fatalf("Expected one series for %v", f.GetName())
+		}
+		if sum := f.GetMetric()[0].GetHistogram().GetSampleSum(); sum != expected[f.GetName()] {
+			t.Errorf("%v should be %v, got %v", f.GetName(), expected[f.GetName()], sum)
+		}
+	}
+}
+
+func TestTrackTransferSizesForwardsWriter(t *testing.T) {
+	a, err := TrackTransferSizesWithOptions(MetricsOptions{Registerer: prometheus.NewRegistry()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hijacked := make(chan bool, 1)
+	ts := httptest.NewServer(a(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.(http.Flusher).Flush()
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+		hijacked <- err == nil
+	})))
+	defer ts.Close()
+
+	ts.Client().Get(ts.URL)
+	if !<-hijacked {
+		t.Error("Writer should support hijacking")
+	}
+}
+
+func TestCountSLOViolations(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	a, err := CountSLOViolationsWithOptions(20*time.Millisecond, MetricsOptions{Registerer: reg})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a(http.HandlerFunc(handlerTester)).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/fast", nil))
+	if v := gatheredValue(t, reg, "http_request_slo_violations_total"); v != 0 {
+		t.Errorf("Fast request should not be a violation, got %v", v)
+	}
+
+	slow := a(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(30 * time.Millisecond)
+	}))
+	slow.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/slow", nil))
+	if v := gatheredValue(t, reg, "http_request_slo_violations_total"); v != 1 {
+		t.Errorf("Slow request should be a violation, got %v", v)
+	}
+	expected := `
+# HELP http_request_slo_violations_total How many HTTP requests took longer than the latency objective, partitioned by endpoint and HTTP method.
+# TYPE http_request_slo_violations_total counter
+http_request_slo_violations_total{endpoint="/slow",method="GET"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "http_request_slo_violations_total"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCountHTTPResponsesStatusClasses(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	a, err := CountHTTPResponsesWithOptions(MetricsOptions{Registerer: reg, StatusClasses: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, status := range []int{http.StatusOK, http.StatusNoContent, http.StatusNotFound} {
+		a(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+		})).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}
+
+	expected := `
+# HELP http_requests_total How many HTTP requests processed, partitioned by endpoint, status code, and HTTP method.
+# TYPE http_requests_total counter
+http_requests_total{code="2xx",endpoint="/",method="GET"} 2
+http_requests_total{code="4xx",endpoint="/",method="GET"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "http_requests_total"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCountPanics(t *testing.T) {
+	var logs bytes.Buffer
+	SetLogger(log.New(&logs, "", 0))
+	defer SetLogger(nil)
+	reg := prometheus.NewRegistry()
+	a, err := CountPanicsWithOptions(MetricsOptions{Registerer: reg})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	a(http.HandlerFunc(handlerPanic)).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("Recovered panic should give a 500, got %v", w.Code)
+	}
+	if !strings.Contains(logs.String(), "goroutine") {
+		t.Errorf("Recovered panic should be logged with the stack trace, got %q", logs.String())
+	}
+	a(http.HandlerFunc(handlerTester)).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if v := gatheredValue(t, reg, "http_panics_total"); v != 1 {
+		t.Errorf("Only the panic should be counted, got %v", v)
+	}
+
+	defer func() {
+		if e := recover(); e != http.ErrAbortHandler {
+			t.Errorf("Response should be aborted once it has started, got %v", e)
+		}
+		if v := gatheredValue(t, reg, "http_panics_total"); v != 2 {
+			t.Errorf("Panic after the response started should be counted, got %v", v)
+		}
+	}()
+	a(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("partial"))
+		panic("oops")
+	})).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+}
+
+// gatheredValue returns the sum of the counter values for the metric in the registry.
+func gatheredValue(t *testing.T, reg *prometheus.Registry, name string) float64 {
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	total := 0.0
+	for _, f := range families {
+		if f.GetName() != name {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			total += m.GetCounter().GetValue()
+		}
+	}
+	return total
+}
+
+func TestClientClass(t *testing.T) {
+	for userAgent, expected := range map[string]string{
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36": "browser",
+		"Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Mobile/15E148":   "browser",
+		"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)":                                        "bot",
+		"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) HeadlessChrome/120.0.0.0 Safari/537.36":   "bot",
+		"facebookexternalhit/1.1 (+http://www.facebook.com/externalhit_uatext.php)":                                       "bot",
+		"curl/8.4.0":         "curl",
+		"Wget/1.21.4":        "curl",
+		"Go-http-client/1.1": "other",
+		"":                   "other",
+	} {
+		if class := ClientClass(userAgent); class != expected {
+			t.Errorf("User-Agent %q should be %v, got %v", userAgent, expected, class)
+		}
+	}
+}
+
+func TestCountByClientClass(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	a, err := CountByClientClassWithOptions(func(userAgent string) string {
+		if strings.HasPrefix(userAgent, "internal-monitor") {
+			return "monitor"
+		}
+		return ClientClass(userAgent)
+	}, MetricsOptions{Registerer: reg})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := a(http.HandlerFunc(handlerTester))
+	for _, userAgent := range []string{"curl/8.4.0", "curl/7.88.1", "internal-monitor/2", "Mozilla/5.0 (Macintosh)"} {
+		req := httptest.NewRequest(http.MethodGet, "/status", nil)
+		req.Header.Set("User-Agent", userAgent)
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	expected := `
+# HELP http_requests_by_client_class_total How many HTTP requests processed, partitioned by endpoint and class of client.
+# TYPE http_requests_by_client_class_total counter
+http_requests_by_client_class_total{class="browser",endpoint="/status"} 1
+http_requests_by_client_class_total{class="curl",endpoint="/status"} 2
+http_requests_by_client_class_total{class="monitor",endpoint="/status"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "http_requests_by_client_class_total"); err != nil {
+		t.Error(err)
+	}
+}
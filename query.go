@@ -0,0 +1,50 @@
+package adaptd
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+// RequireQuery adapter requires the query parameters to be present and not empty.
+// Requests missing one are given a http.StatusBadRequest error naming the first missing parameter.
+func RequireQuery(params ...string) Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			query := r.URL.Query()
+			for _, param := range params {
+				if query.Get(param) == "" {
+					http.Error(w, fmt.Sprintf("Missing query parameter %q", param), http.StatusBadRequest)
+					return
+				}
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ValidateQuery adapter checks the values of query parameters with the rules, keyed by parameter name.
+// Every value of a parameter must pass its rule. Parameters that are not present are not checked,
+// so this is usually applied after RequireQuery. Requests with a value that fails its rule
+// are given a http.StatusBadRequest error naming the parameter.
+func ValidateQuery(rules map[string]func(string) bool) Adapter {
+	params := make([]string, 0, len(rules))
+	for param := range rules {
+		params = append(params, param)
+	}
+	sort.Strings(params)
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			query := r.URL.Query()
+			for _, param := range params {
+				for _, value := range query[param] {
+					if !rules[param](value) {
+						http.Error(w, fmt.Sprintf("Invalid query parameter %q", param), http.StatusBadRequest)
+						return
+					}
+				}
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package adaptd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestRequireQuery(t *testing.T) {
+	h := RequireQuery("q", "page")(http.HandlerFunc(handlerTester))
+	for target, expected := range map[string]int{
+		"/search?q=go&page=2": http.StatusOK,
+		"/search?page=2":      http.StatusBadRequest,
+		"/search?q=&page=2":   http.StatusBadRequest,
+		"/search":             http.StatusBadRequest,
+	} {
+		checkNumber = 0
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		if w.Code != expected || (expected == http.StatusOK) != (checkNumber == 1) {
+			t.Errorf("Request to %v should get %v, got %v", target, expected, w.Code)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/search", nil))
+	if !strings.Contains(w.Body.String(), `"q"`) {
+		t.Errorf("Error should name the first missing parameter, got %q", w.Body.String())
+	}
+}
+
+func TestValidateQuery(t *testing.T) {
+	isNumber := func(v string) bool {
+		_, err := strconv.Atoi(v)
+		return err == nil
+	}
+	h := ValidateQuery(map[string]func(string) bool{"page": isNumber})(http.HandlerFunc(handlerTester))
+	for target, expected := range map[string]int{
+		"/search?page=2":        http.StatusOK,
+		"/search":               http.StatusOK,
+		"/search?page=two":      http.StatusBadRequest,
+		"/search?page=1&page=x": http.StatusBadRequest,
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		if w.Code != expected {
+			t.Errorf("Request to %v should get %v, got %v", target, expected, w.Code)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package adaptd
+
+import (
+	"bufio"
+	"log"
+	"net"
+	"net/http"
+	"runtime/debug"
+)
+
+// Recover adapter recovers from panics in the handler, logs the panic value and stack trace,
+// and gives a http.StatusInternalServerError error. The panic value is never sent to the client.
+// If the response was already started, it is aborted with http.ErrAbortHandler after the panic is logged,
+// so the client sees a broken response instead of a truncated one that looks complete.
+// Panics with http.ErrAbortHandler are not recovered so the server can abort the response.
+func Recover(logger *log.Logger) Adapter {
+	return RecoverWithHandler(logger, nil)
+}
+
+// RecoverWithHandler adapter is like Recover, but the errorHandler is called with the original request
+// to write the response, e.g. to render an error page. If errorHandler is nil, a http.StatusInternalServerError error is given.
+func RecoverWithHandler(logger *log.Logger, errorHandler http.Handler) Adapter {
+	return recoverPanics(func() *log.Logger { return logger }, errorHandler, nil)
+}
+
+// recoverPanics is the recovery shared by RecoverWithHandler and CountPanics. The logger is called when a panic
+// is recovered, so it can be changed after the adapter is created. If onPanic is not nil, it is called for each
+// recovered panic before the response is written.
+func recoverPanics(logger func() *log.Logger, errorHandler http.Handler, onPanic func(*http.Request)) Adapter {
+	if errorHandler == nil {
+		errorHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		})
+	}
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w, sr := captureStatus(w, r)
+			defer func() {
+				e := recover()
+				if e == nil {
+					return
+				}
+				if e == http.ErrAbortHandler {
+					panic(e)
+				}
+				if onPanic != nil {
+					onPanic(r)
+				}
+				logger().Printf("Panic handling %v request at URL %v: %v\n%s", r.Method, r.URL, e, debug.Stack())
+				if sr.wroteHeader {
+					panic(http.ErrAbortHandler)
+				}
+				errorHandler.ServeHTTP(w, r)
+			}()
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// WithFallback adapter serves the fallback, e.g. stale or default content, instead of the handler's response
+// when the handler panics or gives a 5xx status code, like the http.StatusServiceUnavailable of a http.TimeoutHandler.
+// The headers set by the handler are held until its status code is known, so they are not sent with the fallback.
+// Panics are logged, and are not recovered if the handler's response was already started.
+// Panics with http.ErrAbortHandler are not recovered so the server can abort the response.
+func WithFallback(fallback http.Handler) Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fw := &fallbackWriter{ResponseWriter: w, header: make(http.Header)}
+			defer func() {
+				if e := recover(); e != nil {
+					if e == http.ErrAbortHandler || (fw.wroteHeader && !fw.failed) {
+						panic(e)
+					}
+					packageLogger().Printf("Panic handling %v request at URL %v, serving the fallback: %v\n%s", r.Method, r.URL, e, debug.Stack())
+					fallback.ServeHTTP(w, r)
+					return
+				}
+				if !fw.wroteHeader {
+					fw.WriteHeader(http.StatusOK)
+				}
+				if fw.failed {
+					fallback.ServeHTTP(w, r)
+				}
+			}()
+			h.ServeHTTP(fw, r)
+		})
+	}
+}
+
+// fallbackWriter holds the headers until the status code is known and drops 5xx responses.
+type fallbackWriter struct {
+	http.ResponseWriter
+	header      http.Header
+	wroteHeader bool
+	failed      bool
+}
+
+func (f *fallbackWriter) Header() http.Header {
+	return f.header
+}
+
+func (f *fallbackWriter) WriteHeader(code int) {
+	if f.wroteHeader {
+		return
+	}
+	f.wroteHeader = true
+	if code >= http.StatusInternalServerError {
+		f.failed = true
+		return
+	}
+	for name, values := range f.header {
+		f.ResponseWriter.Header()[name] = values
+	}
+	f.ResponseWriter.WriteHeader(code)
+}
+
+func (f *fallbackWriter) Write(b []byte) (int, error) {
+	if !f.wroteHeader {
+		f.WriteHeader(http.StatusOK)
+	}
+	if f.failed {
+		return len(b), nil
+	}
+	return f.ResponseWriter.Write(b)
+}
+
+func (f *fallbackWriter) Flush() {
+	if fl, ok := f.ResponseWriter.(http.Flusher); ok {
+		if !f.wroteHeader {
+			f.WriteHeader(http.StatusOK)
+		}
+		if !f.failed {
+			fl.Flush()
+		}
+	}
+}
+
+// Hijack lets the handler take over the connection. Once it has, nothing more is written to the response.
+func (f *fallbackWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := hijack(f.ResponseWriter)
+	if err == nil {
+		f.wroteHeader = true
+	}
+	return conn, rw, err
+}
+
+// Unwrap returns the underlying http.ResponseWriter for http.ResponseController.
+func (f *fallbackWriter) Unwrap() http.ResponseWriter {
+	return f.ResponseWriter
+}
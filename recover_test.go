@@ -0,0 +1,107 @@
+package adaptd
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRecover(t *testing.T) {
+	var buf bytes.Buffer
+	h := Recover(log.New(&buf, "", 0))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("secret database password")
+	}))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusInternalServerError || strings.Contains(w.Body.String(), "secret") {
+		t.Errorf("Panic should give a 500 without the panic value, got %v %q", w.Code, w.Body.String())
+	}
+	if !strings.Contains(buf.String(), "secret database password") {
+		t.Error("Panic value should be logged")
+	}
+}
+
+func TestRecoverWithHandler(t *testing.T) {
+	var errorRequest *http.Request
+	errorPage := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		errorRequest = r
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("<h1>Something went wrong</h1>"))
+	})
+	h := RecoverWithHandler(log.New(&bytes.Buffer{}, "", 0), errorPage)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("secret database password")
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/account", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if errorRequest == nil || errorRequest.URL.Path != "/account" {
+		t.Error("Error handler should get the original request")
+	}
+	if w.Code != http.StatusInternalServerError || w.Body.String() != "<h1>Something went wrong</h1>" {
+		t.Errorf("Error handler should write the response, got %v %q", w.Code, w.Body.String())
+	}
+}
+
+func TestRecoverAfterPartialResponse(t *testing.T) {
+	called := false
+	errorPage := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	})
+	h := RecoverWithHandler(log.New(&bytes.Buffer{}, "", 0), errorPage)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("partial"))
+		panic("oops")
+	}))
+	w := httptest.NewRecorder()
+	func() {
+		defer func() {
+			if e := recover(); e != http.ErrAbortHandler {
+				t.Errorf("Response should be aborted once it has started, got %v", e)
+			}
+		}()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	}()
+	if called || w.Body.String() != "partial" {
+		t.Error("Error handler should not be called once the response has started")
+	}
+}
+
+func TestWithFallback(t *testing.T) {
+	SetLogger(log.New(&bytes.Buffer{}, "", 0))
+	defer SetLogger(nil)
+	fallback := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("stale content"))
+	})
+
+	for name, primary := range map[string]http.HandlerFunc{
+		"panic": func(w http.ResponseWriter, r *http.Request) {
+			panic("database is down")
+		},
+		"500": func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			http.Error(w, "database is down", http.StatusInternalServerError)
+		},
+		"500 then panic": func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadGateway)
+			panic("database is down")
+		},
+	} {
+		w := httptest.NewRecorder()
+		WithFallback(fallback)(primary).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		if w.Code != http.StatusOK || w.Body.String() != "stale content" || w.Header().Get("Content-Type") == "application/json" {
+			t.Errorf("Fallback should be served after a %v, got %v %q", name, w.Code, w.Body.String())
+		}
+	}
+
+	w := httptest.NewRecorder()
+	WithFallback(fallback)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Source", "primary")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("fresh content"))
+	})).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusCreated || w.Body.String() != "fresh content" || w.Header().Get("X-Source") != "primary" {
+		t.Errorf("Successful response should be served, got %v %q", w.Code, w.Body.String())
+	}
+}
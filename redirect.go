@@ -0,0 +1,306 @@
+package adaptd
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const redirectHopsCookie = "adaptd_redirect_hops"
+
+// DetectRedirectLoop adapter counts, using a cookie, how many redirects have been issued in a row.
+// Redirects to other hosts are counted too, so a loop between two hosts, each with the adapter, is also broken.
+// Once more than maxHops redirects have been issued, the loop is broken by responding with a
+// http.StatusInternalServerError error describing the loop instead of redirecting again.
+func DetectRedirectLoop(maxHops int) Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hops := 0
+			if c, err := r.Cookie(redirectHopsCookie); err == nil {
+				hops, _ = strconv.Atoi(c.Value)
+			}
+			rw := &redirectLoopWriter{ResponseWriter: w, r: r, hops: hops, maxHops: maxHops}
+			h.ServeHTTP(rw, r)
+			if !rw.wroteHeader && hops > 0 {
+				// The handler wrote nothing, so the response is not a redirect and the counter is reset.
+				http.SetCookie(w, &http.Cookie{Name: redirectHopsCookie, Path: "/", MaxAge: -1})
+			}
+		})
+	}
+}
+
+// StripTrailingSlash adapter redirects requests with a trailing slash in the path to the path without one,
+// e.g. /about/ is redirected to /about. The query is preserved and the root path is left alone.
+// A http.StatusPermanentRedirect is used so the request method is preserved.
+func StripTrailingSlash() Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			path := r.URL.EscapedPath()
+			if path != "/" && strings.HasSuffix(path, "/") {
+				redirectToPath(w, r, strings.TrimRight(path, "/"))
+				return
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// EnforceTrailingSlash adapter redirects requests without a trailing slash in the path to the path with one,
+// e.g. /about is redirected to /about/. The query is preserved and the root path is left alone.
+// A http.StatusPermanentRedirect is used so the request method is preserved.
+func EnforceTrailingSlash() Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			path := r.URL.EscapedPath()
+			if path != "/" && path != "" && !strings.HasSuffix(path, "/") {
+				redirectToPath(w, r, path+"/")
+				return
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Redirects adapter redirects requests whose path is a key in the rules to the mapped target, keeping the query.
+// A key ending in /* matches the path before it and every path below it, e.g. /blog/* matches /blog and /blog/2020/post.
+// If the target of such a key also ends in /*, the rest of the path is appended to it, so /blog/* to /news/*
+// redirects /blog/2020/post to /news/2020/post. Exact keys are preferred, then the longest matching key.
+// Other requests are passed to the handler. If status is 0, http.StatusMovedPermanently is used.
+// It panics if the status is not a redirect status.
+func Redirects(rules map[string]string, status int) Adapter {
+	if status == 0 {
+		status = http.StatusMovedPermanently
+	}
+	mustBeRedirectStatus(status)
+	exact := make(map[string]string)
+	var prefixes []string
+	for from, to := range rules {
+		if strings.HasSuffix(from, "/*") {
+			prefixes = append(prefixes, strings.TrimSuffix(from, "/*"))
+		} else {
+			exact[from] = to
+		}
+	}
+	sort.Slice(prefixes, func(i, j int) bool { return len(prefixes[i]) > len(prefixes[j]) })
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			target, ok := exact[r.URL.Path]
+			if !ok {
+				for _, prefix := range prefixes {
+					if hasPathPrefix(r.URL.Path, prefix) {
+						target, ok = rules[prefix+"/*"], true
+						if strings.HasSuffix(target, "/*") {
+							target = strings.TrimSuffix(target, "/*") + strings.TrimPrefix(r.URL.Path, prefix)
+						}
+						break
+					}
+				}
+			}
+			if !ok {
+				h.ServeHTTP(w, r)
+				return
+			}
+			if len(r.URL.RawQuery) > 0 {
+				if strings.Contains(target, "?") {
+					target += "&" + r.URL.RawQuery
+				} else {
+					target += "?" + r.URL.RawQuery
+				}
+			}
+			redirectIfNotStarted(w, r, target, status)
+		})
+	}
+}
+
+// redirectToPath redirects to the given path on the same host, keeping the query.
+func redirectToPath(w http.ResponseWriter, r *http.Request, path string) {
+	// Leading slashes are collapsed so the target cannot be read as a different host, e.g. //evil.com
+	target := "/" + strings.TrimLeft(path, "/")
+	if len(r.URL.RawQuery) > 0 {
+		target += "?" + r.URL.RawQuery
+	}
+	redirectIfNotStarted(w, r, target, http.StatusPermanentRedirect)
+}
+
+// CanonicalHostOptions are the options for CanonicalHostWithOptions.
+type CanonicalHostOptions struct {
+	// Status is the redirect status used. The default is http.StatusMovedPermanently.
+	Status int
+	// AllowLoopback passes through requests to localhost and loopback addresses so local testing is not redirected.
+	AllowLoopback bool
+	// TrustedProxies are the networks of the proxies whose X-Forwarded-Host and X-Forwarded-Proto headers are honored.
+	// For requests coming directly from one of these proxies, the forwarded host is compared to the canonical host
+	// and the forwarded protocol is used for the redirect target. The headers of other requests are ignored.
+	TrustedProxies []net.IPNet
+}
+
+// CanonicalHost adapter redirects requests for any host other than the given one to the same URL on that host,
+// e.g. example.com/about?page=2 to www.example.com/about?page=2. The port of the request is ignored when comparing
+// the hosts, and a non-default port is kept in the redirect, e.g. example.com:8443 to www.example.com:8443.
+// Requests to the canonical host are passed to the handler. It panics if the status is not a redirect status.
+func CanonicalHost(host string, status int) Adapter {
+	return CanonicalHostWithOptions(host, CanonicalHostOptions{Status: status})
+}
+
+// CanonicalHostWithOptions adapter is like CanonicalHost, but is configured with the options.
+func CanonicalHostWithOptions(host string, opts CanonicalHostOptions) Adapter {
+	if opts.Status == 0 {
+		opts.Status = http.StatusMovedPermanently
+	}
+	mustBeRedirectStatus(opts.Status)
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requested := forwardedHost(r, opts.TrustedProxies)
+			name, port, err := net.SplitHostPort(requested)
+			if err != nil {
+				name, port = requested, ""
+			}
+			if strings.EqualFold(requested, host) || strings.EqualFold(name, host) || (opts.AllowLoopback && isLoopback(requested)) {
+				h.ServeHTTP(w, r)
+				return
+			}
+			scheme, defaultPort := "http", "80"
+			if (HTTPSOptions{TrustedProxies: opts.TrustedProxies}).isHTTPS(r) {
+				scheme, defaultPort = "https", "443"
+			}
+			// A non-default port is kept so the request is redirected to the same server on the canonical host.
+			targetHost := host
+			if _, _, err := net.SplitHostPort(host); err != nil && port != "" && port != defaultPort {
+				targetHost = net.JoinHostPort(host, port)
+			}
+			target := scheme + "://" + targetHost + r.URL.EscapedPath()
+			if len(r.URL.RawQuery) > 0 {
+				target += "?" + r.URL.RawQuery
+			}
+			redirectIfNotStarted(w, r, target, opts.Status)
+		})
+	}
+}
+
+// AllowedHostsOptions are the options for AllowedHostsWithOptions.
+type AllowedHostsOptions struct {
+	// AllowLoopback allows requests to localhost and loopback addresses, for local development.
+	AllowLoopback bool
+}
+
+// AllowedHosts adapter gives a http.StatusBadRequest error to requests whose Host, without the port,
+// is not one of the hosts, to prevent Host header injection. The comparison is case-insensitive.
+// A host starting with *. allows every subdomain of the rest, e.g. *.example.com allows api.example.com
+// but not example.com itself.
+func AllowedHosts(hosts ...string) Adapter {
+	return AllowedHostsWithOptions(AllowedHostsOptions{}, hosts...)
+}
+
+// AllowedHostsWithOptions adapter is like AllowedHosts, but is configured with the options.
+func AllowedHostsWithOptions(opts AllowedHostsOptions, hosts ...string) Adapter {
+	exact := make(map[string]bool, len(hosts))
+	var suffixes []string
+	for _, host := range hosts {
+		host = normalizeHost(host)
+		if strings.HasPrefix(host, "*.") {
+			suffixes = append(suffixes, host[1:])
+		} else {
+			exact[host] = true
+		}
+	}
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			host := normalizeHost(stripPort(r.Host))
+			if !exact[host] && !hasHostSuffix(host, suffixes) && !(opts.AllowLoopback && isLoopback(host)) {
+				http.Error(w, "Host not allowed", http.StatusBadRequest)
+				return
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// hasHostSuffix reports whether the host is a subdomain ending in one of the suffixes, e.g. .example.com.
+func hasHostSuffix(host string, suffixes []string) bool {
+	for _, suffix := range suffixes {
+		if strings.HasSuffix(host, suffix) && len(host) > len(suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeHost lower cases the host and removes the trailing dot of a fully qualified name.
+func normalizeHost(host string) string {
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// isLoopback reports whether the host is localhost or a loopback address.
+func isLoopback(host string) bool {
+	host = strings.ToLower(stripPort(host))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+type redirectLoopWriter struct {
+	http.ResponseWriter
+	r           *http.Request
+	hops        int
+	maxHops     int
+	wroteHeader bool
+	broken      bool
+}
+
+func (rw *redirectLoopWriter) WriteHeader(code int) {
+	if rw.wroteHeader {
+		return
+	}
+	rw.wroteHeader = true
+	switch {
+	case isRedirect(code):
+		if rw.hops >= rw.maxHops {
+			// Break the loop and reset the counter so the client can try again later.
+			rw.broken = true
+			target := rw.Header().Get("Location")
+			rw.Header().Del("Location")
+			http.SetCookie(rw.ResponseWriter, &http.Cookie{Name: redirectHopsCookie, Path: "/", MaxAge: -1})
+			packageLogger().Printf("Redirect loop detected at URL %v: %v redirects issued, last to %v\n", rw.r.URL, rw.hops, target)
+			http.Error(rw.ResponseWriter, fmt.Sprintf("Redirect loop detected: %v redirects issued, last to %v", rw.hops, target), http.StatusInternalServerError)
+			return
+		}
+		http.SetCookie(rw.ResponseWriter, &http.Cookie{Name: redirectHopsCookie, Value: strconv.Itoa(rw.hops + 1), Path: "/", HttpOnly: true})
+	case rw.hops > 0:
+		// The redirects ended before reaching the limit so the counter is reset.
+		http.SetCookie(rw.ResponseWriter, &http.Cookie{Name: redirectHopsCookie, Path: "/", MaxAge: -1})
+	}
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *redirectLoopWriter) Write(b []byte) (int, error) {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+	if rw.broken {
+		// The body of the redirect is dropped in favor of the diagnostic.
+		return len(b), nil
+	}
+	return rw.ResponseWriter.Write(b)
+}
+
+// Unwrap returns the underlying http.ResponseWriter for http.ResponseController.
+func (rw *redirectLoopWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+func isRedirect(code int) bool {
+	return code >= http.StatusMultipleChoices && code < http.StatusBadRequest && code != http.StatusNotModified
+}
+
+// stripPort removes the port, if any, from the host.
+func stripPort(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return strings.Trim(host, "[]")
+}
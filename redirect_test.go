@@ -0,0 +1,380 @@
+package adaptd
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestDetectRedirectLoopBreaksLoop(t *testing.T) {
+	checkNumber = 0
+	loop := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		checkNumber++
+		http.Redirect(w, r, "/", http.StatusFound)
+	})
+	ts := httptest.NewServer(DetectRedirectLoop(3)(loop))
+	defer ts.Close()
+
+	client := ts.Client()
+	client.Jar, _ = cookiejar.New(nil)
+	resp, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusInternalServerError || checkNumber != 4 {
+		t.Errorf("Redirect loop should be broken after 3 hops: got status %v after %v requests", resp.StatusCode, checkNumber)
+	}
+	if !strings.Contains(string(body), "Redirect loop detected") {
+		t.Errorf("Expected a diagnostic in the response, got %q", body)
+	}
+	if resp.Header.Get("Location") != "" {
+		t.Error("Broken redirect loop should not include a Location header")
+	}
+}
+
+func TestDetectRedirectLoopBetweenHosts(t *testing.T) {
+	var requests atomic.Int32
+	var hostA, hostB string
+	a := httptest.NewServer(DetectRedirectLoop(2)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		http.Redirect(w, r, "http://"+hostB+"/", http.StatusFound)
+	})))
+	defer a.Close()
+	b := httptest.NewServer(DetectRedirectLoop(2)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		http.Redirect(w, r, "http://"+hostA+"/", http.StatusFound)
+	})))
+	defer b.Close()
+	// The hosts differ, so each keeps its own counter cookie.
+	hostA = a.Listener.Addr().String()
+	hostB = strings.Replace(b.Listener.Addr().String(), "127.0.0.1", "localhost", 1)
+
+	client := a.Client()
+	client.Jar, _ = cookiejar.New(nil)
+	resp, err := client.Get(a.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusInternalServerError || requests.Load() != 5 {
+		t.Errorf("Redirect loop between hosts should be broken, got status %v after %v requests", resp.StatusCode, requests.Load())
+	}
+}
+
+func TestDetectRedirectLoopAllowsShortChains(t *testing.T) {
+	checkNumber = 0
+	redirectOnce := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		checkNumber++
+		if r.URL.Path != "/done" {
+			http.Redirect(w, r, "/done", http.StatusFound)
+		}
+	})
+	ts := httptest.NewServer(DetectRedirectLoop(3)(redirectOnce))
+	defer ts.Close()
+
+	client := ts.Client()
+	client.Jar, _ = cookiejar.New(nil)
+	resp, err := client.Get(ts.URL)
+	if err != nil || resp.StatusCode != http.StatusOK || checkNumber != 2 {
+		t.Error("Short redirect chain should not be broken")
+	}
+	for _, c := range client.Jar.Cookies(resp.Request.URL) {
+		if c.Name == redirectHopsCookie {
+			t.Error("Redirect counter should be reset after the chain ends")
+		}
+	}
+}
+
+func TestStripTrailingSlash(t *testing.T) {
+	checkNumber = 0
+	h := StripTrailingSlash()(http.HandlerFunc(handlerTester))
+	for path, location := range map[string]string{
+		"/about/":        "/about",
+		"/about/?page=2": "/about?page=2",
+		"/a/b//":         "/a/b",
+		"//evil.com/":    "/evil.com",
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusPermanentRedirect || w.Header().Get("Location") != location {
+			t.Errorf("Request to %v should redirect to %v, got %v", path, location, w.Header().Get("Location"))
+		}
+	}
+	for _, path := range []string{"/", "/about", "/about?page=2"} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("Request to %v should not be redirected", path)
+		}
+	}
+	if checkNumber != 3 {
+		t.Error("Canonical requests should reach the handler")
+	}
+}
+
+func TestEnforceTrailingSlash(t *testing.T) {
+	checkNumber = 0
+	h := EnforceTrailingSlash()(http.HandlerFunc(handlerTester))
+	for path, location := range map[string]string{
+		"/about":        "/about/",
+		"/about?page=2": "/about/?page=2",
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, nil))
+		if w.Code != http.StatusPermanentRedirect || w.Header().Get("Location") != location {
+			t.Errorf("Request to %v should redirect to %v, got %v", path, location, w.Header().Get("Location"))
+		}
+	}
+	for _, path := range []string{"/", "/about/", "/about/?page=2"} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("Request to %v should not be redirected", path)
+		}
+	}
+	if checkNumber != 3 {
+		t.Error("Canonical requests should reach the handler")
+	}
+}
+
+func TestCanonicalHostRedirect(t *testing.T) {
+	checkNumber = 0
+	h := CanonicalHost("www.example.com", http.StatusMovedPermanently)(http.HandlerFunc(handlerTester))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com/about?page=2", nil))
+	if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != "http://www.example.com/about?page=2" || checkNumber != 0 {
+		t.Errorf("Non-canonical host should be redirected, got %v to %v", w.Code, w.Header().Get("Location"))
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Header().Get("Location") != "https://www.example.com/" {
+		t.Errorf("Redirect should keep the scheme, got %v", w.Header().Get("Location"))
+	}
+}
+
+func TestCanonicalHostPort(t *testing.T) {
+	checkNumber = 0
+	h := CanonicalHost("www.example.com", http.StatusMovedPermanently)(http.HandlerFunc(handlerTester))
+	for target, location := range map[string]string{
+		"https://example.com:8443/about": "https://www.example.com:8443/about",
+		"https://example.com:443/about":  "https://www.example.com/about",
+		"http://example.com:80/about":    "http://www.example.com/about",
+		"http://example.com:8080/about":  "http://www.example.com:8080/about",
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		if w.Header().Get("Location") != location {
+			t.Errorf("Request to %v should redirect to %v, got %v", target, location, w.Header().Get("Location"))
+		}
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "https://www.example.com:8443/about", nil))
+	if w.Code != http.StatusOK || checkNumber != 1 {
+		t.Errorf("Canonical host on another port should be passed to the handler, got %v", w.Code)
+	}
+}
+
+func TestCanonicalHostPassThrough(t *testing.T) {
+	checkNumber = 0
+	h := CanonicalHost("www.example.com", http.StatusMovedPermanently)(http.HandlerFunc(handlerTester))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://www.example.com/about", nil))
+	if w.Code != http.StatusOK || checkNumber != 1 {
+		t.Error("Canonical host should be passed to the handler")
+	}
+}
+
+func TestCanonicalHostLoopback(t *testing.T) {
+	checkNumber = 0
+	h := CanonicalHostWithOptions("www.example.com", CanonicalHostOptions{AllowLoopback: true})(http.HandlerFunc(handlerTester))
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	resp, err := ts.Client().Get(ts.URL)
+	if err != nil || resp.StatusCode != http.StatusOK || checkNumber != 1 {
+		t.Error("Loopback host should be passed to the handler when allowed")
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com/", nil))
+	if w.Code != http.StatusMovedPermanently {
+		t.Error("Other hosts should still be redirected")
+	}
+}
+
+func TestCanonicalHostForwardedHost(t *testing.T) {
+	_, trusted, _ := net.ParseCIDR("10.0.0.0/8")
+	h := CanonicalHostWithOptions("www.example.com", CanonicalHostOptions{TrustedProxies: []net.IPNet{*trusted}})(http.HandlerFunc(handlerTester))
+	for remoteAddr, expected := range map[string]int{
+		"10.1.2.3:1234":    http.StatusOK,
+		"203.0.113.9:1234": http.StatusMovedPermanently,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "http://internal:8080/about", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("X-Forwarded-Host", "www.example.com")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != expected {
+			t.Errorf("X-Forwarded-Host from %v should give %v, got %v", remoteAddr, expected, w.Code)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://internal:8080/about", nil)
+	req.RemoteAddr = "10.1.2.3:1234"
+	req.Header.Set("X-Forwarded-Host", "example.com")
+	req.Header.Set("X-Forwarded-Proto", "https")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Header().Get("Location") != "https://www.example.com/about" {
+		t.Errorf("Forwarded request should redirect using the forwarded protocol, got %v", w.Header().Get("Location"))
+	}
+}
+
+func TestAllowedHosts(t *testing.T) {
+	h := AllowedHosts("example.com", "*.api.example.com")(http.HandlerFunc(handlerTester))
+	for host, expected := range map[string]int{
+		"example.com":             http.StatusOK,
+		"EXAMPLE.com:8080":        http.StatusOK,
+		"example.com.":            http.StatusOK,
+		"v1.api.example.com":      http.StatusOK,
+		"a.b.api.example.com":     http.StatusOK,
+		"api.example.com":         http.StatusBadRequest,
+		"evil.com":                http.StatusBadRequest,
+		"evilexample.com":         http.StatusBadRequest,
+		"v1.api.example.com.evil": http.StatusBadRequest,
+		"localhost:8080":          http.StatusBadRequest,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Host = host
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != expected {
+			t.Errorf("Host %v should give %v, got %v", host, expected, w.Code)
+		}
+	}
+}
+
+func TestAllowedHostsLoopback(t *testing.T) {
+	h := AllowedHostsWithOptions(AllowedHostsOptions{AllowLoopback: true}, "example.com")(http.HandlerFunc(handlerTester))
+	for host, expected := range map[string]int{
+		"localhost:8080": http.StatusOK,
+		"127.0.0.1":      http.StatusOK,
+		"[::1]:8080":     http.StatusOK,
+		"evil.com":       http.StatusBadRequest,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Host = host
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != expected {
+			t.Errorf("Host %v should give %v, got %v", host, expected, w.Code)
+		}
+	}
+}
+
+func TestRedirects(t *testing.T) {
+	h := Redirects(map[string]string{
+		"/old-about":  "/about",
+		"/blog/*":     "/news/*",
+		"/blog/2019":  "/archive",
+		"/docs/v1/*":  "/docs",
+		"/docs/v1/go": "/docs/go",
+	}, 0)(http.HandlerFunc(handlerTester))
+
+	for target, location := range map[string]string{
+		"/old-about?ref=home":    "/about?ref=home",
+		"/blog":                  "/news",
+		"/blog/2020/post?page=2": "/news/2020/post?page=2",
+		"/blog/2019":             "/archive",
+		"/docs/v1/install":       "/docs",
+		"/docs/v1/go":            "/docs/go",
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != location {
+			t.Errorf("Request to %v should redirect to %v, got %v %v", target, location, w.Code, w.Header().Get("Location"))
+		}
+	}
+
+	for _, target := range []string{"/about", "/blogger", "/docs/v2"} {
+		checkNumber = 0
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		if w.Code != http.StatusOK || checkNumber != 1 {
+			t.Errorf("Request to %v should pass through, got %v", target, w.Code)
+		}
+	}
+}
+
+func TestRedirectsStatus(t *testing.T) {
+	w := httptest.NewRecorder()
+	Redirects(map[string]string{"/a": "/b"}, http.StatusFound)(http.HandlerFunc(handlerTester)).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/a", nil))
+	if w.Code != http.StatusFound {
+		t.Errorf("Redirect should use the given status, got %v", w.Code)
+	}
+}
+
+func TestRedirectSkippedAfterResponseStarted(t *testing.T) {
+	writesFirst := func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("already started"))
+			h.ServeHTTP(w, r)
+		})
+	}
+	for name, redirect := range map[string]Adapter{
+		"EnsureHTTPS":        EnsureHTTPS(false),
+		"StripTrailingSlash": StripTrailingSlash(),
+		"CanonicalHost":      CanonicalHost("example.com", 0),
+	} {
+		var errorLog bytes.Buffer
+		ts := httptest.NewUnstartedServer(Adapt(http.HandlerFunc(handlerTester), CaptureStatus(), writesFirst, redirect))
+		ts.Config.ErrorLog = log.New(&errorLog, "", 0)
+		ts.Start()
+
+		resp, err := ts.Client().Get(ts.URL + "/about/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		ts.Close()
+
+		if resp.StatusCode != http.StatusOK || string(body) != "already started" {
+			t.Errorf("%v should not redirect once the response has started, got %v %q", name, resp.StatusCode, body)
+		}
+		if strings.Contains(errorLog.String(), "superfluous") {
+			t.Errorf("%v wrote the header twice: %v", name, errorLog.String())
+		}
+	}
+}
+
+func TestRedirectSkippedAfterResponseStartedWithoutCaptureStatus(t *testing.T) {
+	writesFirst := func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("already started"))
+			h.ServeHTTP(w, r)
+		})
+	}
+	// The status is recorded by Notify, behind the writer of DefaultContentType.
+	h := Adapt(http.HandlerFunc(handlerTester), Notify(log.New(&bytes.Buffer{}, "", 0)), writesFirst, DefaultContentType("text/plain"), EnsureHTTPS(false))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/about", nil))
+	if w.Code != http.StatusOK || w.Header().Get("Location") != "" {
+		t.Errorf("Redirect should be skipped once a wrapped writer has started the response, got %v %v", w.Code, w.Header().Get("Location"))
+	}
+}
@@ -1,13 +1,184 @@
 package adaptd
 
-import "net/http"
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+)
+
+// CaptureStatus adapter records the status code and size of the response in a single place for the adapters called after it.
+// Adapters that read the status, like Notify, AccessLog, CountHTTPResponses, and Recover, use it instead of
+// recording the status themselves, so they all see the same final status.
+// Redirecting adapters, like EnsureHTTPS and StripTrailingSlash, also use it to skip redirecting once the response has started.
+// The status can be retrieved by handlers with StatusFromContext.
+func CaptureStatus() Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			sr := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			h.ServeHTTP(sr, r.WithContext(context.WithValue(r.Context(), statusKey, sr)))
+		})
+	}
+}
+
+// StatusFromContext returns the status code of the response recorded by CaptureStatus so far.
+// The status is http.StatusOK until the header is written.
+func StatusFromContext(ctx context.Context) (int, bool) {
+	sr, ok := ctx.Value(statusKey).(*statusRecorder)
+	if !ok {
+		return 0, false
+	}
+	return sr.status, true
+}
+
+// captureStatus returns w and the statusRecorder installed by CaptureStatus if w is that recorder.
+// Otherwise, w is wrapped in a new statusRecorder, which is returned as both. Adapters in between,
+// like Buffer, can write to the shared recorder after the handler returns, so it only gives the final status
+// when it is w itself.
+func captureStatus(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, *statusRecorder) {
+	if sr, ok := r.Context().Value(statusKey).(*statusRecorder); ok && w == http.ResponseWriter(sr) {
+		return w, sr
+	}
+	sr := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	return sr, sr
+}
+
+// responseStarted reports whether the response has already been started, as far as can be told.
+// This is known when the response is recorded by CaptureStatus, or w is or wraps a statusRecorder,
+// as the writers of adapters like Notify and MaxBodyBytes do.
+func responseStarted(w http.ResponseWriter, r *http.Request) bool {
+	if sr, ok := r.Context().Value(statusKey).(*statusRecorder); ok && sr.wroteHeader {
+		return true
+	}
+	for {
+		if sr, ok := w.(*statusRecorder); ok && sr.wroteHeader {
+			return true
+		}
+		u, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return false
+		}
+		w = u.Unwrap()
+	}
+}
+
+// redirectIfNotStarted redirects to the target unless the response has already been started,
+// in which case the redirect is logged and skipped to avoid writing the header twice.
+func redirectIfNotStarted(w http.ResponseWriter, r *http.Request, target string, status int) {
+	if responseStarted(w, r) {
+		packageLogger().Printf("Redirect to %v skipped because the response has already started\n", target)
+		return
+	}
+	http.Redirect(w, r, target, status)
+}
 
 type statusRecorder struct {
 	http.ResponseWriter
-	status int
+	status      int
+	bytes       int
+	wroteHeader bool
 }
 
 func (s *statusRecorder) WriteHeader(code int) {
-	s.status = code
+	if !s.wroteHeader {
+		s.status = code
+		s.wroteHeader = true
+	}
 	s.ResponseWriter.WriteHeader(code)
 }
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if !s.wroteHeader {
+		s.WriteHeader(http.StatusOK)
+	}
+	n, err := s.ResponseWriter.Write(b)
+	s.bytes += n
+	return n, err
+}
+
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		if !s.wroteHeader {
+			s.WriteHeader(http.StatusOK)
+		}
+		f.Flush()
+	}
+}
+
+func (s *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return hijack(s.ResponseWriter)
+}
+
+// Unwrap returns the underlying http.ResponseWriter for http.ResponseController.
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// beforeHeaderWriter calls hook with the response headers just before they are written,
+// so adapters can change headers set by any handler.
+type beforeHeaderWriter struct {
+	http.ResponseWriter
+	hook        func(http.Header)
+	wroteHeader bool
+}
+
+func (b *beforeHeaderWriter) WriteHeader(code int) {
+	if !b.wroteHeader {
+		b.wroteHeader = true
+		b.hook(b.Header())
+	}
+	b.ResponseWriter.WriteHeader(code)
+}
+
+func (b *beforeHeaderWriter) Write(p []byte) (int, error) {
+	if !b.wroteHeader {
+		b.WriteHeader(http.StatusOK)
+	}
+	return b.ResponseWriter.Write(p)
+}
+
+func (b *beforeHeaderWriter) Flush() {
+	if f, ok := b.ResponseWriter.(http.Flusher); ok {
+		if !b.wroteHeader {
+			b.WriteHeader(http.StatusOK)
+		}
+		f.Flush()
+	}
+}
+
+// Hijack lets the handler take over the connection. Once it has, nothing more is written to the response.
+func (b *beforeHeaderWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := hijack(b.ResponseWriter)
+	if err == nil {
+		b.wroteHeader = true
+	}
+	return conn, rw, err
+}
+
+// Unwrap returns the underlying http.ResponseWriter for http.ResponseController.
+func (b *beforeHeaderWriter) Unwrap() http.ResponseWriter {
+	return b.ResponseWriter
+}
+
+// hijack lets the handler take over the connection if w supports it, so wrapping writers do not break
+// protocols like WebSockets.
+func hijack(w http.ResponseWriter) (net.Conn, *bufio.ReadWriter, error) {
+	if hj, ok := w.(http.Hijacker); ok {
+		return hj.Hijack()
+	}
+	return nil, nil, fmt.Errorf("adaptd: %T does not support hijacking", w)
+}
+
+// countingReader counts the bytes read from a request body.
+type countingReader struct {
+	io.ReadCloser
+	bytes int
+}
+
+func (c *countingReader) Read(b []byte) (int, error) {
+	n, err := c.ReadCloser.Read(b)
+	c.bytes += n
+	return n, err
+}
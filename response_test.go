@@ -0,0 +1,122 @@
+package adaptd
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCaptureStatusShared(t *testing.T) {
+	var logs bytes.Buffer
+	logger := log.New(&logs, "", 0)
+	reg := prometheus.NewRegistry()
+	count, err := CountHTTPResponsesWithOptions(MetricsOptions{Registerer: reg})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var accessLog bytes.Buffer
+	var fromContext int
+
+	h := Adapt(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+		fromContext, _ = StatusFromContext(r.Context())
+	}), CaptureStatus(), Notify(logger), count, AccessLog(&accessLog, CommonLogFormat))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if fromContext != http.StatusTeapot {
+		t.Errorf("Status on the context should be %v, got %v", http.StatusTeapot, fromContext)
+	}
+	if !strings.Contains(logs.String(), "was handled with status 418") {
+		t.Errorf("Notify should see the status, got %q", logs.String())
+	}
+	if !strings.Contains(accessLog.String(), `HTTP/1.1" 418 -`) {
+		t.Errorf("AccessLog should see the status, got %q", accessLog.String())
+	}
+	expected := `
+# HELP http_requests_total How many HTTP requests processed, partitioned by endpoint, status code, and HTTP method.
+# TYPE http_requests_total counter
+http_requests_total{code="418",endpoint="/",method="GET"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "http_requests_total"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCaptureStatusRecover(t *testing.T) {
+	var logs bytes.Buffer
+	logger := log.New(&logs, "", 0)
+	h := Adapt(http.HandlerFunc(handlerPanic), CaptureStatus(), Notify(logger), Recover(log.New(&bytes.Buffer{}, "", 0)))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if w.Code != http.StatusInternalServerError || !strings.Contains(logs.String(), "was handled with status 500") {
+		t.Errorf("Notify should see the status written by Recover, got %q", logs.String())
+	}
+}
+
+func TestCaptureStatusBehindBuffering(t *testing.T) {
+	for name, buffering := range map[string]Adapter{"Buffer": Buffer(), "ETag": ETag()} {
+		var logs bytes.Buffer
+		h := Adapt(http.HandlerFunc(http.NotFound), CaptureStatus(), buffering, Notify(log.New(&logs, "", 0)))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		if w.Code != http.StatusNotFound || !strings.Contains(logs.String(), "was handled with status 404") {
+			t.Errorf("Notify after %v should see the status %v, got %q", name, w.Code, logs.String())
+		}
+	}
+}
+
+func TestStatusFromContextMissing(t *testing.T) {
+	if _, ok := StatusFromContext(httptest.NewRequest(http.MethodGet, "/", nil).Context()); ok {
+		t.Error("Context without CaptureStatus should not have a status")
+	}
+}
+
+func TestWrappersHijack(t *testing.T) {
+	hijacker := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hj, ok := w.(http.Hijacker)
+		if !ok {
+			t.Errorf("%T should support hijacking", w)
+			return
+		}
+		conn, rw, err := hj.Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 204 No Content\r\nX-Hijacked: true\r\nConnection: close\r\n\r\n")
+		rw.Flush()
+	})
+	for name, a := range map[string]Adapter{
+		"DefaultContentType": DefaultContentType("text/plain"),
+		"HardenCookies":      HardenCookies(),
+		"CSPNonce":           CSPNonce(),
+		"ServerTiming":       ServerTiming(),
+		"WithFallback":       WithFallback(http.NotFoundHandler()),
+		"AllowHead":          AllowHead(),
+		"Buffer":             Buffer(),
+	} {
+		ts := httptest.NewServer(a(hijacker))
+		for _, method := range []string{http.MethodGet, http.MethodHead} {
+			req, _ := http.NewRequest(method, ts.URL, nil)
+			resp, err := ts.Client().Do(req)
+			if err != nil {
+				t.Errorf("%v should allow hijacking %v requests: %v", name, method, err)
+				continue
+			}
+			resp.Body.Close()
+			if resp.Header.Get("X-Hijacked") != "true" {
+				t.Errorf("%v should allow hijacking %v requests, got %v", name, method, resp.Status)
+			}
+		}
+		ts.Close()
+	}
+}
@@ -0,0 +1,103 @@
+package adaptd
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ServerTimings collects the durations recorded by handlers for the Server-Timing header.
+// It is safe for concurrent use.
+type ServerTimings struct {
+	mu      sync.Mutex
+	metrics []string
+}
+
+// Record adds a metric with the name and duration, e.g. Record("db", time.Since(start)).
+func (s *ServerTimings) Record(name string, d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.metrics = append(s.metrics, serverTimingMetric(name, d))
+}
+
+func (s *ServerTimings) header(total time.Duration) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return strings.Join(append(append([]string{}, s.metrics...), serverTimingMetric("total", total)), ", ")
+}
+
+func serverTimingMetric(name string, d time.Duration) string {
+	return name + ";dur=" + strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 1, 64)
+}
+
+// ServerTiming adapter adds a Server-Timing header with the metrics recorded by handlers followed by a total metric,
+// the time taken by the handler in milliseconds. Handlers record metrics with the ServerTimings from ServerTimingFromContext.
+// The header is written with the response, so metrics recorded after the handler starts writing the body are not included.
+func ServerTiming() Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tw := &serverTimingWriter{ResponseWriter: w, timings: &ServerTimings{}, start: time.Now()}
+			h.ServeHTTP(tw, r.WithContext(context.WithValue(r.Context(), serverTimingKey, tw.timings)))
+			if !tw.wroteHeader {
+				tw.Header().Set("Server-Timing", tw.timings.header(time.Since(tw.start)))
+			}
+		})
+	}
+}
+
+// ServerTimingFromContext returns the ServerTimings stored on the context by ServerTiming.
+func ServerTimingFromContext(ctx context.Context) (*ServerTimings, bool) {
+	s, ok := ctx.Value(serverTimingKey).(*ServerTimings)
+	return s, ok
+}
+
+// serverTimingWriter adds the Server-Timing header before the header is written.
+type serverTimingWriter struct {
+	http.ResponseWriter
+	timings     *ServerTimings
+	start       time.Time
+	wroteHeader bool
+}
+
+func (s *serverTimingWriter) WriteHeader(code int) {
+	if !s.wroteHeader {
+		s.wroteHeader = true
+		s.Header().Set("Server-Timing", s.timings.header(time.Since(s.start)))
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *serverTimingWriter) Write(b []byte) (int, error) {
+	if !s.wroteHeader {
+		s.WriteHeader(http.StatusOK)
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+func (s *serverTimingWriter) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		if !s.wroteHeader {
+			s.WriteHeader(http.StatusOK)
+		}
+		f.Flush()
+	}
+}
+
+// Hijack lets the handler take over the connection. Once it has, nothing more is written to the response.
+func (s *serverTimingWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := hijack(s.ResponseWriter)
+	if err == nil {
+		s.wroteHeader = true
+	}
+	return conn, rw, err
+}
+
+// Unwrap returns the underlying http.ResponseWriter for http.ResponseController.
+func (s *serverTimingWriter) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
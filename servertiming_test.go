@@ -0,0 +1,36 @@
+package adaptd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestServerTiming(t *testing.T) {
+	h := ServerTiming()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timings, ok := ServerTimingFromContext(r.Context())
+		if !ok {
+			t.Fatal("ServerTimings should be on the context")
+		}
+		timings.Record("db", 12*time.Millisecond+340*time.Microsecond)
+		w.Write([]byte("hello"))
+	}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	header := w.Header().Get("Server-Timing")
+	if !regexp.MustCompile(`^db;dur=12\.3, total;dur=\d+\.\d$`).MatchString(header) {
+		t.Errorf("Unexpected Server-Timing header %q", header)
+	}
+}
+
+func TestServerTimingWithoutBody(t *testing.T) {
+	h := ServerTiming()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if !regexp.MustCompile(`^total;dur=\d+\.\d$`).MatchString(w.Header().Get("Server-Timing")) {
+		t.Errorf("Unexpected Server-Timing header %q", w.Header().Get("Server-Timing"))
+	}
+}
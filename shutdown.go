@@ -0,0 +1,67 @@
+package adaptd
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// RequestTracker tracks the requests being handled so a server can wait for them before shutting down.
+// Once stopped, new requests are given the ServiceUnavailable response with a Retry-After of 30 seconds,
+// and the connection is closed. The zero value is ready to use.
+type RequestTracker struct {
+	mu       sync.Mutex
+	stopped  bool
+	inFlight sync.WaitGroup
+}
+
+// Adapter returns an Adapter that tracks each request until the handler returns, even if it panics.
+func (t *RequestTracker) Adapter() Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !t.start() {
+				w.Header().Set("Connection", "close")
+				ServiceUnavailable(30*time.Second).ServeHTTP(w, r)
+				return
+			}
+			defer t.inFlight.Done()
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Stop makes the adapter reject new requests. Requests already being handled are not affected.
+func (t *RequestTracker) Stop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.stopped = true
+}
+
+// Drain stops new requests and waits for the requests being handled to finish.
+// If the context is done first, its error is returned.
+func (t *RequestTracker) Drain(ctx context.Context) error {
+	t.Stop()
+	done := make(chan struct{})
+	go func() {
+		t.inFlight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// start adds a request being handled, unless the tracker is stopped.
+func (t *RequestTracker) start() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.stopped {
+		return false
+	}
+	t.inFlight.Add(1)
+	return true
+}
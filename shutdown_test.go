@@ -0,0 +1,88 @@
+package adaptd
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRequestTrackerDrainWaitsForRequests(t *testing.T) {
+	tracker := &RequestTracker{}
+	started, release := make(chan struct{}), make(chan struct{})
+	h := tracker.Adapter()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}))
+
+	finished := make(chan struct{})
+	go func() {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		close(finished)
+	}()
+	<-started
+
+	drained := make(chan error)
+	go func() { drained <- tracker.Drain(context.Background()) }()
+	select {
+	case <-drained:
+		t.Fatal("Drain should wait for the request being handled")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	if err := <-drained; err != nil {
+		t.Error(err)
+	}
+	<-finished
+}
+
+func TestRequestTrackerDrainTimeout(t *testing.T) {
+	tracker := &RequestTracker{}
+	started, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	h := tracker.Adapter()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}))
+	go h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := tracker.Drain(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Drain should return the context error, got %v", err)
+	}
+}
+
+func TestRequestTrackerStop(t *testing.T) {
+	tracker := &RequestTracker{}
+	checkNumber = 0
+	h := tracker.Adapter()(http.HandlerFunc(handlerTester))
+	tracker.Stop()
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusServiceUnavailable || checkNumber != 0 {
+		t.Error("Requests after Stop should be rejected")
+	}
+	if w.Header().Get("Retry-After") != "30" || w.Header().Get("Cache-Control") != "no-store" || w.Header().Get("Connection") != "close" {
+		t.Errorf("Rejected request should be given the ServiceUnavailable response, got %v", w.Header())
+	}
+}
+
+func TestRequestTrackerPanic(t *testing.T) {
+	tracker := &RequestTracker{}
+	h := tracker.Adapter()(http.HandlerFunc(handlerPanic))
+	func() {
+		defer func() { recover() }()
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := tracker.Drain(ctx); err != nil {
+		t.Error("Request that panicked should not be in flight")
+	}
+}
@@ -0,0 +1,49 @@
+package adaptd
+
+import (
+	"context"
+	"net/http"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// SingleFlight adapter coalesces requests with safe methods, like GET, that have the same key while one is being handled.
+// Only the first request is passed to the handler. Its response is buffered and the same status, headers,
+// and body are given to every request that arrived while it was being handled, except for Set-Cookie headers,
+// which are only given to the first request. The handler is called with a context that is not cancelled
+// when the first client goes away, so the others are still given the response.
+// If keyFunc is nil, the key is the method, path, and query of the request.
+// Requests with other methods, or with Authorization or Cookie headers, are passed directly to the handler,
+// as their responses may be meant for that client alone.
+func SingleFlight(keyFunc func(*http.Request) string) Adapter {
+	if keyFunc == nil {
+		keyFunc = func(r *http.Request) string {
+			return r.Method + " " + r.URL.RequestURI()
+		}
+	}
+	var group singleflight.Group
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !isSafeMethod(r.Method) || r.Header.Get("Authorization") != "" || r.Header.Get("Cookie") != "" {
+				h.ServeHTTP(w, r)
+				return
+			}
+			leader := false
+			v, _, _ := group.Do(keyFunc(r), func() (any, error) {
+				leader = true
+				rr := newResponseRecorder()
+				h.ServeHTTP(rr, r.WithContext(context.WithoutCancel(r.Context())))
+				return rr, nil
+			})
+			rr := v.(*responseRecorder)
+			for name, values := range rr.header {
+				if !leader && http.CanonicalHeaderKey(name) == "Set-Cookie" {
+					continue
+				}
+				w.Header()[name] = append([]string(nil), values...)
+			}
+			w.WriteHeader(rr.status)
+			w.Write(rr.body.Bytes())
+		})
+	}
+}
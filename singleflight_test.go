@@ -0,0 +1,126 @@
+package adaptd
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// serveConcurrently serves n requests made by newRequest at the same time, waiting until they have all
+// arrived before release is closed, and returns their responses.
+func serveConcurrently(h http.Handler, n int, newRequest func() *http.Request, release chan struct{}) []*httptest.ResponseRecorder {
+	var arrived sync.WaitGroup
+	arrived.Add(n)
+	recorders := make([]*httptest.ResponseRecorder, n)
+	var done sync.WaitGroup
+	for i := range recorders {
+		recorders[i] = httptest.NewRecorder()
+		done.Add(1)
+		go func(w *httptest.ResponseRecorder) {
+			defer done.Done()
+			arrived.Done()
+			h.ServeHTTP(w, newRequest())
+		}(recorders[i])
+	}
+	arrived.Wait()
+	// Give the requests time to join the first one before it is released.
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	done.Wait()
+	return recorders
+}
+
+func TestSingleFlight(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	h := SingleFlight(nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		<-release
+		w.Header().Set("X-Version", "7")
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("expensive"))
+	}))
+	recorders := serveConcurrently(h, 10, func() *http.Request {
+		return httptest.NewRequest(http.MethodGet, "/report?year=2020", nil)
+	}, release)
+
+	if calls.Load() != 1 {
+		t.Errorf("Handler should be called once, got %v", calls.Load())
+	}
+	for _, w := range recorders {
+		if w.Code != http.StatusAccepted || w.Header().Get("X-Version") != "7" || w.Body.String() != "expensive" {
+			t.Errorf("Every request should get the same response, got %v %q %q", w.Code, w.Header().Get("X-Version"), w.Body)
+		}
+	}
+}
+
+func TestSingleFlightSetCookie(t *testing.T) {
+	release := make(chan struct{})
+	h := SingleFlight(nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "secret"})
+		w.Write([]byte("expensive"))
+	}))
+	recorders := serveConcurrently(h, 10, func() *http.Request {
+		return httptest.NewRequest(http.MethodGet, "/", nil)
+	}, release)
+
+	withCookie := 0
+	for _, w := range recorders {
+		if w.Header().Get("Set-Cookie") != "" {
+			withCookie++
+		}
+	}
+	if withCookie != 1 {
+		t.Errorf("Only the first request should be given the Set-Cookie header, got %v", withCookie)
+	}
+}
+
+func TestSingleFlightCredentials(t *testing.T) {
+	for _, header := range []string{"Authorization", "Cookie"} {
+		var calls atomic.Int32
+		release := make(chan struct{})
+		h := SingleFlight(nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			<-release
+		}))
+		serveConcurrently(h, 3, func() *http.Request {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set(header, "secret")
+			return req
+		}, release)
+
+		if calls.Load() != 3 {
+			t.Errorf("Requests with the %v header should not be coalesced, got %v calls", header, calls.Load())
+		}
+	}
+}
+
+func TestSingleFlightDetachedContext(t *testing.T) {
+	var err error
+	h := SingleFlight(nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err = r.Context().Err()
+	}))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx))
+
+	if err != nil {
+		t.Errorf("Handler should not see the first client going away, got %v", err)
+	}
+}
+
+func TestSingleFlightUnsafeMethods(t *testing.T) {
+	checkNumber = 0
+	h := SingleFlight(nil)(http.HandlerFunc(handlerTester))
+	for i := 0; i < 2; i++ {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", nil))
+	}
+	if checkNumber != 2 {
+		t.Errorf("POST requests should not be coalesced, got %v calls", checkNumber)
+	}
+}
@@ -0,0 +1,67 @@
+package adaptd
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// DeadlineFromHeader adapter sets a deadline on the request's context from the named header.
+// The header can be a duration like "2s" or "500ms", or an RFC 3339 timestamp.
+// The deadline is never more than max from now. Missing or malformed values are ignored.
+func DeadlineFromHeader(header string, max time.Duration) Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			value := r.Header.Get(header)
+			if value == "" {
+				h.ServeHTTP(w, r)
+				return
+			}
+			now := time.Now()
+			var deadline time.Time
+			if d, err := time.ParseDuration(value); err == nil && d > 0 {
+				deadline = now.Add(d)
+			} else if t, err := time.Parse(time.RFC3339, value); err == nil {
+				deadline = t
+			} else {
+				h.ServeHTTP(w, r)
+				return
+			}
+			if latest := now.Add(max); deadline.After(latest) {
+				deadline = latest
+			}
+			ctx, cancel := context.WithDeadline(r.Context(), deadline)
+			defer cancel()
+			h.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// HardTimeout adapter cancels the request's context after d and sets a write deadline of d from now on the connection.
+// Cancelling the context, as http.TimeoutHandler and DeadlineFromHeader do, is cooperative: a handler that ignores
+// the context keeps running and the client keeps waiting for it. With the write deadline, writes after d fail and
+// the connection is closed, so the client is not sent a late response, even by a handler that ignores the context.
+// Only the context is cancelled, and the failure is logged, if the ResponseWriter does not support write deadlines.
+// The writers of the adapters in this package support them when the underlying ResponseWriter does.
+func HardTimeout(d time.Duration) Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			deadline := time.Now().Add(d)
+			rc := http.NewResponseController(w)
+			if err := rc.SetWriteDeadline(deadline); err != nil {
+				packageLogger().Printf("Failed to set a write deadline for URL %v, only the context will be cancelled: %v\n", r.URL, err)
+			} else {
+				defer func() {
+					// The server does not reset the deadline for the next request on the connection,
+					// so it is cleared if the response can still be written.
+					if time.Now().Before(deadline) {
+						rc.SetWriteDeadline(time.Time{})
+					}
+				}()
+			}
+			ctx, cancel := context.WithDeadline(r.Context(), deadline)
+			defer cancel()
+			h.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package adaptd
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func deadlineRecorder(deadline *time.Time, ok *bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*deadline, *ok = r.Context().Deadline()
+	})
+}
+
+func TestDeadlineFromHeader(t *testing.T) {
+	var deadline time.Time
+	var ok bool
+	h := DeadlineFromHeader("X-Request-Timeout", 10*time.Second)(deadlineRecorder(&deadline, &ok))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Request-Timeout", "2s")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if d := time.Until(deadline); !ok || d > 2*time.Second || d < time.Second {
+		t.Errorf("Duration header should set the deadline 2s from now, got %v", d)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Request-Timeout", time.Now().Add(3*time.Second).Format(time.RFC3339))
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if d := time.Until(deadline); !ok || d > 3*time.Second || d < time.Second {
+		t.Errorf("Timestamp header should set the deadline, got %v", d)
+	}
+}
+
+func TestDeadlineFromHeaderCapped(t *testing.T) {
+	var deadline time.Time
+	var ok bool
+	h := DeadlineFromHeader("X-Request-Timeout", time.Second)(deadlineRecorder(&deadline, &ok))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Request-Timeout", "1h")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if d := time.Until(deadline); !ok || d > time.Second {
+		t.Errorf("Deadline should be capped at the max, got %v", d)
+	}
+}
+
+func TestDeadlineFromHeaderMalformed(t *testing.T) {
+	var deadline time.Time
+	var ok bool
+	h := DeadlineFromHeader("X-Request-Timeout", time.Second)(deadlineRecorder(&deadline, &ok))
+
+	for _, value := range []string{"soon", "-5s", "2020-13-45"} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Request-Timeout", value)
+		h.ServeHTTP(httptest.NewRecorder(), req)
+		if ok {
+			t.Errorf("Malformed value %q should be ignored", value)
+		}
+	}
+}
+
+func TestHardTimeout(t *testing.T) {
+	cancelled := make(chan bool, 1)
+	ts := httptest.NewServer(HardTimeout(50 * time.Millisecond)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The handler ignores the context and responds late.
+		time.Sleep(200 * time.Millisecond)
+		cancelled <- r.Context().Err() != nil
+		w.Write([]byte("late"))
+	})))
+	defer ts.Close()
+
+	if resp, err := ts.Client().Get(ts.URL); err == nil {
+		resp.Body.Close()
+		t.Errorf("Connection should be closed after the timeout, got %v", resp.Status)
+	}
+	if !<-cancelled {
+		t.Error("Context should be cancelled after the timeout")
+	}
+}
+
+func TestHardTimeoutWrapped(t *testing.T) {
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte("late"))
+	})
+	for name, a := range map[string]Adapter{
+		"CaptureStatus":      CaptureStatus(),
+		"DefaultContentType": DefaultContentType("text/plain"),
+		"Brotli":             Brotli(5),
+	} {
+		ts := httptest.NewServer(Adapt(slow, a, HardTimeout(50*time.Millisecond)))
+		req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+		req.Header.Set("Accept-Encoding", "br")
+		if resp, err := ts.Client().Do(req); err == nil {
+			resp.Body.Close()
+			t.Errorf("Connection should be closed after the timeout behind %v, got %v", name, resp.Status)
+		}
+		ts.Close()
+	}
+}
+
+func TestHardTimeoutUnsupported(t *testing.T) {
+	var buf bytes.Buffer
+	SetLogger(log.New(&buf, "", 0))
+	defer SetLogger(nil)
+
+	HardTimeout(time.Second)(http.HandlerFunc(handlerTester)).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if !strings.Contains(buf.String(), "Failed to set a write deadline for URL /") {
+		t.Errorf("Failure to set the write deadline should be logged, got %q", buf.String())
+	}
+}
+
+func TestHardTimeoutInTime(t *testing.T) {
+	ts := httptest.NewServer(HardTimeout(time.Second)(http.HandlerFunc(helloHandler)))
+	defer ts.Close()
+
+	for i := 0; i < 2; i++ {
+		resp, err := ts.Client().Get(ts.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != "hello, world" {
+			t.Errorf("Request handled in time should be given the response, got %q", body)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package adaptd
+
+import (
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Trace adapter starts a server span for each request with the given tracer. The trace context is extracted
+// from the request headers with the global propagator and the span is put on the request context so
+// handlers can start child spans. The span is named by the method and, if the request was routed by
+// an http.ServeMux, the matched pattern. The response status is recorded and 5xx responses mark the span as an error.
+func Trace(tracer trace.Tracer) Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+			ctx, span := tracer.Start(ctx, spanName(r), trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("url.path", r.URL.Path),
+			))
+			defer span.End()
+
+			w, sr := captureStatus(w, r)
+			r = r.WithContext(ctx)
+			h.ServeHTTP(w, r)
+
+			span.SetName(spanName(r))
+			if route := routePattern(r); route != "" {
+				span.SetAttributes(attribute.String("http.route", route))
+			}
+			span.SetAttributes(attribute.Int("http.response.status_code", sr.status))
+			if sr.status >= http.StatusInternalServerError {
+				span.SetStatus(codes.Error, http.StatusText(sr.status))
+			}
+		})
+	}
+}
+
+// spanName is the method followed by the route the request matched, if any.
+func spanName(r *http.Request) string {
+	if route := routePattern(r); route != "" {
+		return r.Method + " " + route
+	}
+	return r.Method
+}
+
+// routePattern is the pattern the request matched without its method, e.g. /users/{id} for "GET /users/{id}".
+func routePattern(r *http.Request) string {
+	if _, route, ok := strings.Cut(r.Pattern, " "); ok {
+		return strings.TrimSpace(route)
+	}
+	return r.Pattern
+}
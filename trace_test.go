@@ -0,0 +1,62 @@
+package adaptd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTrace(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("adaptd")
+
+	var handlerSpan trace.SpanContext
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		handlerSpan = trace.SpanContextFromContext(r.Context())
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	Trace(tracer)(mux).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users/1", nil))
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("Expected one span, got %v", len(spans))
+	}
+	span := spans[0]
+	if span.Name() != "GET /users/{id}" || span.SpanKind() != trace.SpanKindServer {
+		t.Errorf("Unexpected span name %q or kind %v", span.Name(), span.SpanKind())
+	}
+	if !handlerSpan.IsValid() || handlerSpan.SpanID() != span.SpanContext().SpanID() {
+		t.Error("Span should be on the request context")
+	}
+	found := false
+	for _, attr := range span.Attributes() {
+		if attr == attribute.Int("http.response.status_code", http.StatusInternalServerError) {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Span should have the status code attribute, got %v", span.Attributes())
+	}
+	if span.Status().Code != codes.Error {
+		t.Error("5xx response should mark the span as an error")
+	}
+}
+
+func TestTraceSuccess(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("adaptd")
+
+	Trace(tracer)(http.HandlerFunc(helloHandler)).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", nil))
+
+	spans := recorder.Ended()
+	if len(spans) != 1 || spans[0].Name() != http.MethodPost || spans[0].Status().Code == codes.Error {
+		t.Error("Successful request should end a span named by the method without an error")
+	}
+}
@@ -0,0 +1,95 @@
+package adaptd
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// vendorVersion matches the version in a vendor media type subtype, e.g. vnd.myapi.v2+json.
+var vendorVersion = regexp.MustCompile(`\.v(\d+(?:\.\d+)*)(?:\+|$)`)
+
+// APIVersion adapter determines the version of the API requested from the media types in the Accept header.
+// The version is read from the media type parameter with the given name (application/json; version=2)
+// or from the subtype of a vendor media type (application/vnd.myapi.v2+json).
+// If the requested version is not one of the supported versions, a http.StatusNotAcceptable error is given.
+// If no version is requested, the first supported version is used.
+// The version can be retrieved by handlers with APIVersionFromContext.
+func APIVersion(param string, supported ...string) Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requested := requestedVersions(r.Header.Get("Accept"), param)
+			version := ""
+			if len(requested) == 0 && len(supported) > 0 {
+				version = supported[0]
+			}
+			for _, v := range requested {
+				if version = matchVersion(v, supported); version != "" {
+					break
+				}
+			}
+			if version == "" {
+				http.Error(w, "API version not supported", http.StatusNotAcceptable)
+				return
+			}
+			h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiVersionKey, version)))
+		})
+	}
+}
+
+// APIVersionFromContext returns the API version stored on the context by APIVersion.
+func APIVersionFromContext(ctx context.Context) (string, bool) {
+	v, ok := ctx.Value(apiVersionKey).(string)
+	return v, ok
+}
+
+// Deprecate adapter adds headers telling clients the endpoint is deprecated before calling the handler:
+// Deprecation: true, a Sunset header with the time the endpoint will stop responding,
+// and a Link header to the documentation of the deprecation, e.g. a migration guide.
+// The Sunset header is not added if sunset is the zero time, and the Link header is not added if link is empty.
+func Deprecate(sunset time.Time, link string) Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Deprecation", "true")
+			if !sunset.IsZero() {
+				w.Header().Set("Sunset", sunset.UTC().Format(http.TimeFormat))
+			}
+			if link != "" {
+				w.Header().Add("Link", fmt.Sprintf("<%s>; rel=\"deprecation\"", link))
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// requestedVersions returns the versions in the media ranges of the Accept header, in order.
+func requestedVersions(accept, param string) []string {
+	var versions []string
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(mediaRange))
+		if err != nil {
+			continue
+		}
+		if v, ok := params[param]; ok && param != "" {
+			versions = append(versions, v)
+		} else if m := vendorVersion.FindStringSubmatch(mediaType[strings.Index(mediaType, "/")+1:]); m != nil {
+			versions = append(versions, m[1])
+		}
+	}
+	return versions
+}
+
+// matchVersion returns the supported version that matches the requested one, ignoring a leading "v".
+func matchVersion(requested string, supported []string) string {
+	requested = strings.TrimPrefix(strings.ToLower(requested), "v")
+	for _, s := range supported {
+		if strings.TrimPrefix(strings.ToLower(s), "v") == requested {
+			return s
+		}
+	}
+	return ""
+}
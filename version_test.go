@@ -0,0 +1,77 @@
+package adaptd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func versionRecorder(version *string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*version, _ = APIVersionFromContext(r.Context())
+	})
+}
+
+func TestAPIVersionSupported(t *testing.T) {
+	var version string
+	h := APIVersion("version", "v1", "v2")(versionRecorder(&version))
+
+	for _, accept := range []string{"application/vnd.myapi.v2+json", "application/json; version=2", "text/html, application/vnd.myapi.v2"} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		version = ""
+		h.ServeHTTP(w, req)
+		if w.Code != http.StatusOK || version != "v2" {
+			t.Errorf("Accept %q should give version v2, got %q with status %v", accept, version, w.Code)
+		}
+	}
+}
+
+func TestAPIVersionUnsupported(t *testing.T) {
+	var version string
+	h := APIVersion("version", "v1", "v2")(versionRecorder(&version))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept", "application/vnd.myapi.v3+json")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusNotAcceptable || version != "" {
+		t.Error("Unsupported version should not be acceptable")
+	}
+}
+
+func TestAPIVersionDefault(t *testing.T) {
+	var version string
+	h := APIVersion("version", "v1", "v2")(versionRecorder(&version))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || version != "v1" {
+		t.Errorf("Missing version should use the default, got %q", version)
+	}
+}
+
+func TestDeprecate(t *testing.T) {
+	sunset := time.Date(2025, time.June, 30, 23, 59, 59, 0, time.FixedZone("EST", -5*60*60))
+	h := Deprecate(sunset, "https://example.com/docs/v1-migration")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Link", `<https://example.com/v1/users?page=2>; rel="next"`)
+		handlerTester(w, r)
+	}))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/users", nil))
+
+	if w.Header().Get("Deprecation") != "true" {
+		t.Errorf("Deprecation header should be true, got %q", w.Header().Get("Deprecation"))
+	}
+	if expected := "Tue, 01 Jul 2025 04:59:59 GMT"; w.Header().Get("Sunset") != expected {
+		t.Errorf("Sunset header should be %q, got %q", expected, w.Header().Get("Sunset"))
+	}
+	links := w.Header().Values("Link")
+	if len(links) != 2 || links[0] != `<https://example.com/docs/v1-migration>; rel="deprecation"` {
+		t.Errorf("Link header should have the deprecation link and the handler's link, got %q", links)
+	}
+}